@@ -0,0 +1,155 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// AppendOptions configures Append.
+type AppendOptions struct {
+	// Variant is the variant of the mbox, which decides how the message
+	// body is escaped.
+	Variant Variant
+
+	// Create creates the mbox if it does not exist, with permissions Perm
+	// (0600 if zero).
+	Create bool
+	Perm   os.FileMode
+
+	// Lock configures the lock held on the mbox while appending. A nil Lock
+	// uses the defaults of Lock, with DefaultLockMethods.
+	Lock *LockOptions
+
+	// NoLock appends without locking the mbox. It is only safe if no other
+	// process writes to the mbox at the same time: concurrent deliveries
+	// would write over each other.
+	NoLock bool
+}
+
+// appendFile is the part of *os.File used by Append.
+type appendFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+// Append appends the message read from r to the mbox at path, with a
+// separator line made from the envelope sender from and the delivery date t
+// (see Writer.CreateMessage). It returns the offset of the separator line.
+//
+// If the mbox does not end with the blank line required before a separator,
+// it is added first. The data is synced to disk before Append returns; if
+// writing fails, the file is truncated back to its original size, so that it
+// never ends with part of a message. The mbox is locked while appending
+// unless opts.NoLock is set.
+func Append(path, from string, t time.Time, r io.Reader, opts *AppendOptions) (int64, error) {
+	if opts == nil {
+		opts = &AppendOptions{}
+	}
+
+	var buf bytes.Buffer
+	w := NewVariantWriter(&buf, opts.Variant)
+	if err := w.WriteMessage(from, t, r); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	flag, perm := os.O_RDWR, opts.Perm
+	if opts.Create {
+		flag |= os.O_CREATE
+	}
+	if perm == 0 {
+		perm = 0o600
+	}
+
+	f, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		return 0, err
+	}
+
+	var l *MailboxLock
+	if !opts.NoLock {
+		if l, err = Lock(f, opts.Lock); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+
+	off, err := appendData(f, buf.Bytes())
+	if l != nil {
+		if uerr := l.Unlock(); err == nil {
+			err = uerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return off, err
+}
+
+// appendData writes a formatted message at the end of f, after the newlines
+// needed to end f with a blank line.
+func appendData(f appendFile, data []byte) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	pad, err := missingNewlines(f, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := f.WriteAt(append([]byte(pad), data...), size); err != nil {
+		return 0, truncateAfterError(f, size, err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, truncateAfterError(f, size, err)
+	}
+
+	return size + int64(len(pad)), nil
+}
+
+// missingNewlines returns the newlines to write so that the first size bytes
+// of f end with a blank line. An empty file needs none.
+func missingNewlines(f io.ReaderAt, size int64) (string, error) {
+	if size == 0 {
+		return "", nil
+	}
+
+	n := min(size, 3)
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, size-n); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasSuffix(b, []byte("\n\n")), bytes.HasSuffix(b, []byte("\n\r\n")):
+		return "", nil
+	case size == 1 && b[0] == '\n', size == 2 && bytes.Equal(b, []byte("\r\n")):
+		// The file holds a lone empty line.
+		return "", nil
+	case bytes.HasSuffix(b, []byte("\n")):
+		return "\n", nil
+	}
+
+	return "\n\n", nil
+}
+
+// truncateAfterError restores the original size of f after a failed write,
+// and returns err.
+func truncateAfterError(f appendFile, size int64, err error) error {
+	if terr := f.Truncate(size); terr == nil {
+		f.Sync()
+	}
+
+	return err
+}
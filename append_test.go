@@ -0,0 +1,187 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	date := time.Date(2015, 1, 1, 0, 0, 5, 0, time.UTC)
+	msg := "From: e@example.com\nSubject: Appended\n\nFrom the spool.\n"
+	appended := "From e@example.com Thu Jan  1 00:00:05 2015\nFrom: e@example.com\nSubject: Appended\n\n>From the spool.\n\n"
+
+	tests := []struct {
+		name, data, pad string
+	}{
+		{"empty", "", ""},
+		{"blank line", mboxWithOneMessage + "\n", ""},
+		{"CRLF blank line", "From a@example.com Thu Jan  1 00:00:01 2015\r\nFrom: a@example.com\r\nSubject: x\r\n\r\nBody\r\n\r\n", ""},
+		{"missing blank line", mboxWithOneMessage, "\n"},
+		{"missing newline", strings.TrimSuffix(mboxWithOneMessage, "\n"), "\n\n"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "spool")
+		if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		off, err := Append(path, "e@example.com", date, strings.NewReader(msg), nil)
+		if err != nil {
+			t.Fatalf("%s - Append() = %v", tt.name, err)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := tt.data + tt.pad + appended; string(b) != want {
+			t.Errorf("%s - Expected:\n%q\ngot\n%q", tt.name, want, b)
+		}
+		if want := int64(len(tt.data) + len(tt.pad)); off != want {
+			t.Errorf("%s - Append() = %d, want %d", tt.name, off, want)
+		}
+
+		// The result must read back as one more message.
+		f, _ := os.Open(path)
+		r := NewReader(f)
+		n := 0
+		for {
+			if _, err := r.NextMessage(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s - Unexpected error after NextMessage(): %v", tt.name, err)
+			}
+			n++
+		}
+		f.Close()
+		want := 2
+		if tt.data == "" {
+			want = 1
+		}
+		if n != want {
+			t.Errorf("%s - Read %d messages, want %d", tt.name, n, want)
+		}
+		if r.Offset() != off {
+			t.Errorf("%s - Last message at %d, want %d", tt.name, r.Offset(), off)
+		}
+	}
+}
+
+func TestAppendCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+
+	if _, err := Append(path, "", time.Time{}, strings.NewReader("Subject: x\n\ny\n"), nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+
+	if _, err := Append(path, "", time.Time{}, strings.NewReader("Subject: x\n\ny\n"), &AppendOptions{Create: true, Perm: 0o660}); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o660&^currentUmask(t) {
+		t.Errorf("Unexpected file mode: %v, %v", info.Mode(), err)
+	}
+}
+
+// currentUmask returns the permission bits cleared by the umask.
+func currentUmask(t *testing.T) os.FileMode {
+	p := filepath.Join(t.TempDir(), "umask")
+	if err := os.WriteFile(p, nil, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return 0o777 &^ info.Mode().Perm()
+}
+
+// failingFile writes only part of the data before failing, like a full disk.
+type failingFile struct {
+	*os.File
+}
+
+func (f failingFile) WriteAt(p []byte, off int64) (int, error) {
+	n, _ := f.File.WriteAt(p[:len(p)/2], off)
+	return n, errors.New("no space left on device")
+}
+
+func TestAppendTruncatesOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	if err := os.WriteFile(path, []byte(mboxWithOneMessage), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data := formatMessage("e@example.com", time.Now(), []byte("Subject: x\n\ny\n"), Mboxrd)
+	if _, err := appendData(failingFile{f}, data); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != mboxWithOneMessage {
+		t.Errorf("Expected the original mbox, got:\n%q", b)
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	msg := "From: e@example.com\nSubject: Appended\n\nHi.\n"
+
+	// Appends are locked by default, so none of them is lost.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Append(path, "e@example.com", time.Time{}, strings.NewReader(msg), &AppendOptions{Create: true})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Append() = %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := NewReader(f)
+	n := 0
+	for {
+		if _, err := r.NextMessage(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+		n++
+	}
+	if n != 20 {
+		t.Errorf("Read %d messages, want 20", n)
+	}
+}
@@ -0,0 +1,258 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// babylEOOH separates the original header of a Babyl message from the header
+// shown to the user.
+const babylEOOH = "*** EOOH ***"
+
+// BabylMessage holds the Rmail state of a Babyl message.
+type BabylMessage struct {
+	// Attributes are the built-in Rmail attributes set on the message:
+	// "unseen", "deleted", "answered", "forwarded", "edited", "filed",
+	// "resent" or "retried".
+	Attributes []string
+	// Labels are the user-defined labels of the message.
+	Labels []string
+}
+
+// BabylReader reads a Babyl (Emacs Rmail) file. Messages are returned with
+// their original header and CRLF line endings, like those of Reader.
+type BabylReader struct {
+	r       *bufio.Reader
+	cr      *countingReader
+	started bool
+	atEOF   bool
+	labels  []string
+	cur     *BabylMessage
+	index   int
+	offset  int64
+	next    int64
+}
+
+// NewBabylReader returns a new BabylReader to read messages from Babyl data
+// provided by io.Reader r.
+func NewBabylReader(r io.Reader) *BabylReader {
+	cr := &countingReader{r: r}
+
+	return &BabylReader{r: bufio.NewReader(cr), cr: cr, index: -1}
+}
+
+// Labels returns the labels declared in the Babyl options header. It is
+// populated by the first call to NextMessage.
+func (r *BabylReader) Labels() []string {
+	return r.labels
+}
+
+// Message returns the attributes and labels of the message most recently
+// returned by NextMessage.
+func (r *BabylReader) Message() *BabylMessage {
+	return r.cur
+}
+
+// Index returns the zero-based index of the message most recently returned by
+// NextMessage, or -1 if NextMessage has not returned a message yet.
+func (r *BabylReader) Index() int {
+	return r.index
+}
+
+// Offset returns the byte offset of the delimiter line preceding the message
+// most recently returned by NextMessage.
+func (r *BabylReader) Offset() int64 {
+	return r.offset
+}
+
+// readLine returns the next line without its line ending.
+func (r *BabylReader) readLine() ([]byte, error) {
+	line, err := r.r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+
+	return bytes.TrimRight(line, "\r\n"), err
+}
+
+// delimiter reports whether line starts a new message. A lone "\x1f" line
+// ends the file, unless the next line is a form feed.
+func (r *BabylReader) delimiter(line []byte) (bool, error) {
+	if len(line) == 0 || line[0] != '\x1f' {
+		return false, nil
+	}
+
+	if bytes.Equal(line, []byte("\x1f\x0c")) {
+		return true, nil
+	}
+
+	if b, err := r.r.Peek(1); err == nil && b[0] == '\x0c' {
+		_, err := r.readLine()
+		return true, err
+	}
+
+	r.atEOF = true
+
+	return false, nil
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (r *BabylReader) NextMessage() (io.Reader, error) {
+	if !r.started {
+		if err := r.readOptions(); err != nil {
+			return nil, err
+		}
+		r.started = true
+	}
+
+	if r.atEOF {
+		return nil, io.EOF
+	}
+
+	status, err := r.readLine()
+	if err == io.EOF {
+		r.atEOF = true
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	r.cur = parseBabylStatus(string(status))
+	r.offset = r.next
+	r.index++
+
+	var lines [][]byte
+	for {
+		off := position(r.cr, r.r)
+		line, err := r.readLine()
+		if err == io.EOF {
+			r.atEOF = true
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		ok, err := r.delimiter(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			r.next = off
+			break
+		}
+		if r.atEOF {
+			break
+		}
+
+		lines = append(lines, line)
+	}
+
+	return bytes.NewReader(babylMessage(lines)), nil
+}
+
+// readOptions reads the Babyl file header up to the first message.
+func (r *BabylReader) readOptions() error {
+	line, err := r.readLine()
+	if err == io.EOF || (err == nil && !bytes.HasPrefix(line, []byte("BABYL OPTIONS:"))) {
+		return ErrInvalidFormat
+	} else if err != nil {
+		return err
+	}
+
+	for {
+		off := position(r.cr, r.r)
+		line, err := r.readLine()
+		if err == io.EOF {
+			r.atEOF = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		ok, err := r.delimiter(line)
+		if err != nil || ok || r.atEOF {
+			r.next = off
+			return err
+		}
+
+		if name, value, ok := strings.Cut(string(line), ":"); ok && strings.EqualFold(name, "Labels") {
+			r.labels = splitBabylList(value)
+		}
+	}
+}
+
+// parseBabylStatus parses the line starting a Babyl message, such as
+// "1, answered, unseen,, work, urgent,". The leading digit tells whether the
+// header was reformatted; attributes and user labels are separated by ",,".
+func parseBabylStatus(line string) *BabylMessage {
+	if i := strings.IndexByte(line, ','); i >= 0 {
+		line = line[i:]
+	}
+
+	attrs, labels, _ := strings.Cut(line, ",,")
+
+	return &BabylMessage{Attributes: splitBabylList(attrs), Labels: splitBabylList(labels)}
+}
+
+func splitBabylList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// babylMessage rebuilds a message from the lines stored for it. When the
+// original header was kept before the EOOH line, it replaces the visible
+// header which follows that line, without the Summary-line field Rmail adds.
+func babylMessage(lines [][]byte) []byte {
+	var original, rest [][]byte
+	for i, l := range lines {
+		if string(l) == babylEOOH {
+			original, rest = lines[:i], lines[i+1:]
+			break
+		}
+	}
+	if rest == nil && original == nil {
+		rest = lines
+	}
+
+	if len(original) > 0 {
+		// Rmail adds a Summary-line field of its own to the original
+		// header.
+		if bytes.HasPrefix(bytes.ToLower(original[0]), []byte("summary-line:")) {
+			original = original[1:]
+			for len(original) > 0 && len(original[0]) > 0 && (original[0][0] == ' ' || original[0][0] == '\t') {
+				original = original[1:]
+			}
+		}
+
+		// Skip the visible header, and its blank line if the original
+		// header ends with one, as Rmail writes it.
+		var body [][]byte
+		for i, l := range rest {
+			if len(l) == 0 {
+				if n := len(original); n > 0 && len(original[n-1]) == 0 {
+					i++
+				}
+				body = rest[i:]
+				break
+			}
+		}
+		rest = append(original, body...)
+	}
+
+	var b bytes.Buffer
+	for _, l := range rest {
+		b.Write(l)
+		b.WriteString("\r\n")
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,116 @@
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const babylWithTwoMessages = "BABYL OPTIONS: -*- rmail -*-\n" +
+	"Version: 5\n" +
+	"Labels: work,urgent\n" +
+	"Note:   This is the header of an rmail file.\n" +
+	"\x1f\x0c\n" +
+	"1, answered, unseen,, work,\n" +
+	"Summary-line: 3-Jan  herp.derp  Test\n" +
+	"From: herp.derp@example.com (Herp Derp)\n" +
+	"Subject: Test\n" +
+	"Message-ID: <1@example.com>\n" +
+	"\n" +
+	"*** EOOH ***\n" +
+	"From: herp.derp@example.com (Herp Derp)\n" +
+	"Subject: Test\n" +
+	"\n" +
+	"This is a simple test.\n" +
+	"\x1f\x0c\n" +
+	"0, deleted,,\n" +
+	"*** EOOH ***\n" +
+	"From: derp.herp@example.com (Derp Herp)\n" +
+	"Subject: Another test\n" +
+	"\n" +
+	"Bye.\n" +
+	"\x1f"
+
+func TestBabylReader(t *testing.T) {
+	r := NewBabylReader(strings.NewReader(babylWithTwoMessages))
+
+	want := []struct {
+		subject string
+		msgID   string
+		body    string
+		offset  int64
+		babyl   *BabylMessage
+	}{
+		{"Test", "<1@example.com>", "This is a simple test.\r\n", int64(strings.Index(babylWithTwoMessages, "\x1f\x0c\n1,")),
+			&BabylMessage{Attributes: []string{"answered", "unseen"}, Labels: []string{"work"}}},
+		{"Another test", "", "Bye.\r\n", int64(strings.Index(babylWithTwoMessages, "\x1f\x0c\n0,")),
+			&BabylMessage{Attributes: []string{"deleted"}}},
+	}
+
+	for i, w := range want {
+		msg, err := r.NextMessage()
+		if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+
+		body, err := io.ReadAll(m.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Header.Get("Subject") != w.subject || m.Header.Get("Message-ID") != w.msgID || string(body) != w.body {
+			t.Errorf("%d - Unexpected message %q / %q", i, m.Header.Get("Subject"), body)
+		}
+		if r.Offset() != w.offset || r.Index() != i {
+			t.Errorf("%d - Unexpected position %d at %d", i, r.Index(), r.Offset())
+		}
+		if !reflect.DeepEqual(r.Message(), w.babyl) {
+			t.Errorf("%d - Message() = %+v, want %+v", i, r.Message(), w.babyl)
+		}
+	}
+
+	if _, err := r.NextMessage(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	if got := r.Labels(); !reflect.DeepEqual(got, []string{"work", "urgent"}) {
+		t.Errorf("Labels() = %q", got)
+	}
+}
+
+func TestBabylReaderReformatted(t *testing.T) {
+	msg, err := NewBabylReader(strings.NewReader(babylWithTwoMessages)).NextMessage()
+	if err != nil {
+		t.Fatalf("Unexpected error after NextMessage(): %v", err)
+	}
+
+	b, err := io.ReadAll(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The original header replaces the visible one, without Summary-line.
+	want := "From: herp.derp@example.com (Herp Derp)\r\nSubject: Test\r\nMessage-ID: <1@example.com>\r\n\r\nThis is a simple test.\r\n"
+	if string(b) != want {
+		t.Errorf("Expected:\n%q\ngot\n%q", want, b)
+	}
+}
+
+func TestBabylReaderEmpty(t *testing.T) {
+	r := NewBabylReader(strings.NewReader("BABYL OPTIONS: -*- rmail -*-\nVersion: 5\n\x1f"))
+	if _, err := r.NextMessage(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	r = NewBabylReader(strings.NewReader(mboxWithOneMessage))
+	if _, err := r.NextMessage(); err != ErrInvalidFormat {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bundleMboxFile is the name of the mbox file inside an Apple Mail bundle.
+const bundleMboxFile = "mbox"
+
+var ErrNotBundle = errors.New("not an Apple Mail mailbox bundle")
+
+// BundleMessage describes a message read from an Apple Mail bundle.
+type BundleMessage struct {
+	// Mailbox is the slash-separated path of the mailbox holding the
+	// message, made of the bundle names without their ".mbox" extension,
+	// such as "Archive/2020".
+	Mailbox string
+	// Path is the path of the mbox file holding the message.
+	Path string
+	// Index is the zero-based index of the message in its mbox file.
+	Index int
+	// Offset is the byte offset of the message in its mbox file.
+	Offset int64
+}
+
+// mailboxFile is an mbox file holding one folder of a mailbox tree.
+type mailboxFile struct {
+	name string
+	path string
+}
+
+// mailboxFiles reads the mbox files of a mailbox tree one after the other.
+type mailboxFiles struct {
+	boxes []mailboxFile
+	box   mailboxFile
+	f     *os.File
+	r     *Reader
+	opts  *ReaderOptions
+}
+
+// next returns the next message of the current file, moving on to the next
+// file at its end.
+func (mf *mailboxFiles) next() (io.Reader, error) {
+	for {
+		if mf.r != nil {
+			msg, err := mf.r.NextMessage()
+			if err != io.EOF {
+				return msg, err
+			}
+
+			mf.f.Close()
+			mf.f, mf.r = nil, nil
+		}
+
+		if len(mf.boxes) == 0 {
+			return nil, io.EOF
+		}
+
+		mf.box, mf.boxes = mf.boxes[0], mf.boxes[1:]
+
+		f, err := os.Open(mf.box.path)
+		if err != nil {
+			return nil, err
+		}
+		mf.f, mf.r = f, NewReaderOptions(f, mf.opts)
+	}
+}
+
+func (mf *mailboxFiles) close() error {
+	if mf.f == nil {
+		return nil
+	}
+
+	err := mf.f.Close()
+	mf.f, mf.r = nil, nil
+
+	return err
+}
+
+// BundleReader reads the messages of an Apple Mail .mbox bundle, as exported
+// by macOS Mail: a directory holding an mbox file named "mbox", along with a
+// table_of_contents and an Info.plist which are ignored. Nested mailboxes are
+// child bundles, which are read after their parent in name order.
+type BundleReader struct {
+	files mailboxFiles
+	cur   *BundleMessage
+}
+
+// NewBundleReader returns a BundleReader for the bundle at dir. It returns
+// ErrNotBundle if dir is not a directory or contains no mailbox.
+func NewBundleReader(dir string) (*BundleReader, error) {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return nil, ErrNotBundle
+	} else if err != nil {
+		return nil, err
+	}
+
+	r := &BundleReader{}
+	if err := r.collect(dir, bundleName(filepath.Base(dir))); err != nil {
+		return nil, err
+	}
+
+	if len(r.files.boxes) == 0 {
+		return nil, ErrNotBundle
+	}
+
+	return r, nil
+}
+
+// collect adds the mailbox of the bundle at dir, then those of its child
+// bundles.
+func (r *BundleReader) collect(dir, name string) error {
+	p := filepath.Join(dir, bundleMboxFile)
+	if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+		r.files.boxes = append(r.files.boxes, mailboxFile{name: name, path: p})
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, e := range entries {
+		if !e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".mbox") {
+			continue
+		}
+
+		if err := r.collect(filepath.Join(dir, e.Name()), path.Join(name, bundleName(e.Name()))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func bundleName(name string) string {
+	if ext := filepath.Ext(name); strings.EqualFold(ext, ".mbox") {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	return name
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (r *BundleReader) NextMessage() (io.Reader, error) {
+	msg, err := r.files.next()
+	if err != nil {
+		r.cur = nil
+		return nil, err
+	}
+
+	f := &r.files
+	r.cur = &BundleMessage{Mailbox: f.box.name, Path: f.box.path, Index: f.r.Index(), Offset: f.r.Offset()}
+
+	return msg, nil
+}
+
+// Message describes the message most recently returned by NextMessage.
+func (r *BundleReader) Message() *BundleMessage {
+	return r.cur
+}
+
+// Close closes the mbox file being read.
+func (r *BundleReader) Close() error {
+	return r.files.close()
+}
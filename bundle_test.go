@@ -0,0 +1,81 @@
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBundleReader(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Archive.mbox")
+	for p, data := range map[string]string{
+		"mbox":                           mboxWithOneMessage,
+		"table_of_contents":              "",
+		"Info.plist":                     "<plist/>",
+		"2020.mbox/mbox":                 mboxWithThreeMessages,
+		"2020.mbox/Spam.mbox/mbox":       "",
+		"2019.mbox/Info.plist":           "<plist/>",
+		"2019.mbox/Lists.mbox/mbox":      mboxWithOneMessage,
+		"Attachments/mbox":               mboxWithOneMessage,
+		"2020.mbox/table_of_contents":    "",
+		"2019.mbox/Lists.mbox/Notes.txt": "",
+	} {
+		p = filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewBundleReader(root)
+	if err != nil {
+		t.Fatalf("NewBundleReader() = %v", err)
+	}
+	defer r.Close()
+
+	var got []string
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		if _, err := mail.ReadMessage(msg); err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+
+		m := r.Message()
+		if filepath.Base(m.Path) != "mbox" {
+			t.Errorf("Unexpected path %s", m.Path)
+		}
+		got = append(got, m.Mailbox+":"+strconv.Itoa(m.Index))
+	}
+
+	want := "Archive:0|Archive/2019/Lists:0|Archive/2020:0|Archive/2020:1|Archive/2020:2"
+	if strings.Join(got, "|") != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, strings.Join(got, "|"))
+	}
+}
+
+func TestBundleReaderInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewBundleReader(dir); err != ErrNotBundle {
+		t.Errorf("Expected ErrNotBundle, got %v", err)
+	}
+
+	p := filepath.Join(dir, "mbox")
+	if err := os.WriteFile(p, []byte(mboxWithOneMessage), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBundleReader(p); err != ErrNotBundle {
+		t.Errorf("Expected ErrNotBundle, got %v", err)
+	}
+}
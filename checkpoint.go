@@ -0,0 +1,131 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidCheckpoint  = errors.New("invalid checkpoint")
+	ErrCheckpointMismatch = errors.New("checkpoint does not match mbox")
+)
+
+// Checkpoint is the position of a Reader after a message, from which reading
+// can be resumed with NewReaderAt. It records the offset and the index of the
+// message, and a hash of its separator line, so that a changed mbox is
+// detected. The zero Checkpoint is the start of the data.
+//
+// A Checkpoint is stored with MarshalText and restored with UnmarshalText.
+type Checkpoint struct {
+	offset int64
+	n      int
+	sum    [8]byte
+}
+
+// Checkpoint returns the position after the message most recently returned by
+// NextMessage.
+func (r *Reader) Checkpoint() Checkpoint {
+	if r.index < 0 {
+		return Checkpoint{}
+	}
+
+	return Checkpoint{offset: r.offset, n: r.index + 1, sum: separatorSum(r.sep)}
+}
+
+// NewReaderAt returns a Reader resuming at cp, which was returned by the
+// Checkpoint method of a Reader of the same data: NextMessage returns the
+// message following the one cp was taken after, and Index and Offset continue
+// from cp. It returns ErrCheckpointMismatch if rs no longer has the same
+// separator line at the offset of cp.
+func NewReaderAt(rs io.ReadSeeker, cp Checkpoint) (*Reader, error) {
+	if _, err := rs.Seek(cp.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	cr := &countingReader{r: rs, n: cp.offset}
+	r := &Reader{r: bufio.NewReader(cr), cr: cr, index: -1}
+	if cp.n == 0 {
+		return r, nil
+	}
+
+	b, isPrefix, err := r.r.ReadLine()
+	if err == io.EOF {
+		return nil, ErrCheckpointMismatch
+	} else if err != nil {
+		return nil, err
+	}
+	b = bytes.Clone(b)
+
+	// Discard the rest of the line.
+	for isPrefix {
+		_, isPrefix, err = r.r.ReadLine()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	if !bytes.HasPrefix(b, []byte("From ")) || separatorSum(b) != cp.sum {
+		return nil, ErrCheckpointMismatch
+	}
+
+	// The message itself is skipped by the first call to NextMessage.
+	r.index = cp.n - 1
+	r.offset = cp.offset
+	r.sep = b
+	r.mr = &messageReader{r: r.r, cr: r.cr}
+
+	return r, nil
+}
+
+// MarshalText encodes cp as a short string.
+func (cp Checkpoint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d:%x", cp.offset, cp.n, cp.sum)), nil
+}
+
+// UnmarshalText decodes a Checkpoint encoded by MarshalText.
+func (cp *Checkpoint) UnmarshalText(text []byte) error {
+	fields := strings.Split(string(text), ":")
+	if len(fields) != 3 {
+		return ErrInvalidCheckpoint
+	}
+
+	offset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || offset < 0 {
+		return ErrInvalidCheckpoint
+	}
+
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n < 0 {
+		return ErrInvalidCheckpoint
+	}
+
+	sum, err := hex.DecodeString(fields[2])
+	if err != nil || len(sum) != len(cp.sum) {
+		return ErrInvalidCheckpoint
+	}
+
+	*cp = Checkpoint{offset: offset, n: n, sum: [8]byte(sum)}
+
+	return nil
+}
+
+// String returns the text encoding of cp.
+func (cp Checkpoint) String() string {
+	b, _ := cp.MarshalText()
+
+	return string(b)
+}
+
+// separatorSum hashes a separator line for a Checkpoint.
+func separatorSum(sep []byte) [8]byte {
+	sum := sha256.Sum256(sep)
+
+	return [8]byte(sum[:8])
+}
@@ -0,0 +1,113 @@
+package mbox
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+type readMessage struct {
+	index  int
+	offset int64
+	data   string
+}
+
+func readAllMessages(t *testing.T, r *Reader) []readMessage {
+	t.Helper()
+
+	var msgs []readMessage
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return msgs
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			t.Fatalf("Unexpected error reading message: %v", err)
+		}
+
+		msgs = append(msgs, readMessage{r.Index(), r.Offset(), string(b)})
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	for _, mbox := range []string{mboxWithThreeMessages, strings.ReplaceAll(mboxWithThreeMessages, "\n", "\r\n")} {
+		want := readAllMessages(t, NewReader(strings.NewReader(mbox)))
+
+		r := NewReader(strings.NewReader(mbox))
+		cps := []Checkpoint{r.Checkpoint()}
+		for range want {
+			msg, err := r.NextMessage()
+			if err != nil {
+				t.Fatalf("Unexpected error after NextMessage(): %v", err)
+			}
+			io.Copy(io.Discard, msg)
+			cps = append(cps, r.Checkpoint())
+		}
+
+		for i, cp := range cps {
+			b, err := json.Marshal(cp)
+			if err != nil {
+				t.Fatalf("json.Marshal() = %v", err)
+			}
+
+			var restored Checkpoint
+			if err := json.Unmarshal(b, &restored); err != nil || restored != cp {
+				t.Fatalf("%d - Checkpoint %s restored as %s, %v", i, b, restored, err)
+			}
+
+			r, err := NewReaderAt(strings.NewReader(mbox), restored)
+			if err != nil {
+				t.Fatalf("%d - NewReaderAt() = %v", i, err)
+			}
+
+			got := readAllMessages(t, r)
+			if len(got) != len(want)-i {
+				t.Fatalf("%d - Expected %d messages, got %d", i, len(want)-i, len(got))
+			}
+			for j := range got {
+				if got[j] != want[i+j] {
+					t.Errorf("%d - Expected %+v, got %+v", i, want[i+j], got[j])
+				}
+			}
+
+			if r.Checkpoint() != cps[len(cps)-1] {
+				t.Errorf("%d - Expected final checkpoint %s, got %s", i, cps[len(cps)-1], r.Checkpoint())
+			}
+		}
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	r := NewReader(strings.NewReader(mboxWithThreeMessages))
+	for i := 0; i < 2; i++ {
+		msg, _ := r.NextMessage()
+		io.Copy(io.Discard, msg)
+	}
+	cp := r.Checkpoint()
+
+	changed := strings.Replace(mboxWithThreeMessages, "From derp.herp@example.com Thu Jan  1", "From derp.herp@example.com Fri Jan  2", 1)
+	if _, err := NewReaderAt(strings.NewReader(changed), cp); err != ErrCheckpointMismatch {
+		t.Errorf("Expected ErrCheckpointMismatch, got %v", err)
+	}
+
+	shifted := "\n" + mboxWithThreeMessages
+	if _, err := NewReaderAt(strings.NewReader(shifted), cp); err != ErrCheckpointMismatch {
+		t.Errorf("Expected ErrCheckpointMismatch, got %v", err)
+	}
+
+	truncated := mboxWithThreeMessages[:cp.offset]
+	if _, err := NewReaderAt(strings.NewReader(truncated), cp); err != ErrCheckpointMismatch {
+		t.Errorf("Expected ErrCheckpointMismatch, got %v", err)
+	}
+
+	for _, text := range []string{"", "1:2", "a:1:0011223344556677", "1:-1:0011223344556677", "1:1:00112233", "1:1:zz11223344556677"} {
+		if err := new(Checkpoint).UnmarshalText([]byte(text)); err != ErrInvalidCheckpoint {
+			t.Errorf("%q - Expected ErrInvalidCheckpoint, got %v", text, err)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/attilabuti/mbox"
+)
+
+// message is a message read from an mbox, with the header parsed.
+type message struct {
+	index  int
+	offset int64
+	sep    string
+	// data is the message as stored, without its separator line and with
+	// LF line endings.
+	data      []byte
+	header    mail.Header
+	headerErr error
+}
+
+// nextMessage reads the next message of r. It returns io.EOF if there are no
+// messages left.
+func nextMessage(r *mbox.Reader) (*message, error) {
+	msg, err := r.NextMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &message{index: r.Index(), offset: r.Offset(), sep: r.Separator(), data: bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))}
+	if mm, err := mail.ReadMessage(bytes.NewReader(m.data)); err != nil {
+		m.header, m.headerErr = mail.Header{}, err
+	} else {
+		m.header = mm.Header
+	}
+
+	return m, nil
+}
+
+// date returns the Date header of m, or the date of its separator line.
+func (m *message) date() time.Time {
+	if t, err := m.header.Date(); err == nil {
+		return t
+	}
+
+	_, t, _ := mbox.ParseSeparator(m.sep)
+
+	return t
+}
+
+// get returns the decoded value of a header field.
+func (m *message) get(name string) string {
+	v := m.header.Get(name)
+	if d, err := new(mime.WordDecoder).DecodeHeader(v); err == nil {
+		v = d
+	}
+
+	return strings.Join(strings.Fields(v), " ")
+}
+
+// sender returns the address in the From header of m, or its envelope
+// sender.
+func (m *message) sender() string {
+	if addr, err := mail.ParseAddress(m.header.Get("From")); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+
+	from, _, _ := mbox.ParseSeparator(m.sep)
+
+	return strings.ToLower(from)
+}
+
+// forEach calls fn with every message of the mbox read from in.
+func (c *cmdEnv) forEach(in io.Reader, fn func(m *message) error) error {
+	r := mbox.NewReader(in)
+	for {
+		m, err := nextMessage(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func setupCount(fs *flag.FlagSet) runFunc {
+	return func(c *cmdEnv, args []string) error {
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		n := 0
+		if err := c.forEach(in, func(*message) error { n++; return nil }); err != nil {
+			return err
+		}
+
+		return c.report(c.stdout, map[string]int{"messages": n}, "%d\n", n)
+	}
+}
+
+type listEntry struct {
+	Index   int    `json:"index"`
+	Offset  int64  `json:"offset"`
+	Date    string `json:"date"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+}
+
+func setupLs(fs *flag.FlagSet) runFunc {
+	return func(c *cmdEnv, args []string) error {
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		return c.forEach(in, func(m *message) error {
+			e := listEntry{Index: m.index, Offset: m.offset, Date: formatDate(m.date()), From: m.get("From"), Subject: m.get("Subject")}
+
+			return c.report(c.stdout, e, "%d\t%d\t%s\t%s\t%s\n", e.Index, e.Offset, e.Date, e.From, e.Subject)
+		})
+	}
+}
+
+type catResult struct {
+	Index     int    `json:"index"`
+	Offset    int64  `json:"offset"`
+	Separator string `json:"separator"`
+	Message   string `json:"message"`
+}
+
+func setupCat(fs *flag.FlagSet) runFunc {
+	return func(c *cmdEnv, args []string) error {
+		if len(args) == 0 {
+			return usageError("missing message number")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return usageError(fmt.Sprintf("invalid message number %q", args[0]))
+		}
+
+		in, err := c.openInput(args[1:])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		errFound := errors.New("found")
+		err = c.forEach(in, func(m *message) error {
+			if m.index != n {
+				return nil
+			}
+
+			data := c.variant.Unescape(m.data)
+			if err := c.report(c.stdout, catResult{m.index, m.offset, m.sep, string(data)}, "%s", data); err != nil {
+				return err
+			}
+
+			return errFound
+		})
+		if err == errFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("no message %d", n)
+	}
+}
+
+type problem struct {
+	Index   int    `json:"index"`
+	Offset  int64  `json:"offset"`
+	Problem string `json:"problem"`
+}
+
+type validateResult struct {
+	Messages int       `json:"messages"`
+	Valid    bool      `json:"valid"`
+	Problems []problem `json:"problems"`
+}
+
+func setupValidate(fs *flag.FlagSet) runFunc {
+	return func(c *cmdEnv, args []string) error {
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		res := validateResult{Problems: []problem{}}
+		add := func(m *message, format string, args ...any) {
+			res.Problems = append(res.Problems, problem{m.index, m.offset, fmt.Sprintf(format, args...)})
+		}
+
+		err = c.forEach(in, func(m *message) error {
+			res.Messages++
+
+			if _, _, err := mbox.ParseSeparator(m.sep); err != nil {
+				add(m, "invalid separator line %q", m.sep)
+			}
+			if m.headerErr != nil {
+				add(m, "invalid header: %v", m.headerErr)
+			}
+			if c.variant == mbox.Mboxcl || c.variant == mbox.Mboxcl2 {
+				if p := checkContentLength(m); p != "" {
+					add(m, "%s", p)
+				}
+			}
+
+			return nil
+		})
+		if err == mbox.ErrInvalidFormat {
+			// The data does not start with a separator line.
+			res.Problems = append(res.Problems, problem{res.Messages, 0, "data before the first separator line"})
+		} else if err != nil {
+			return err
+		}
+
+		res.Valid = len(res.Problems) == 0
+		if c.json {
+			err = c.report(c.stdout, res, "")
+		} else {
+			for _, p := range res.Problems {
+				fmt.Fprintf(c.stdout, "message %d at offset %d: %s\n", p.Index, p.Offset, p.Problem)
+			}
+			_, err = fmt.Fprintf(c.stdout, "%d messages, %d problems\n", res.Messages, len(res.Problems))
+		}
+		if err != nil {
+			return err
+		}
+
+		if !res.Valid {
+			return errProblems
+		}
+
+		return nil
+	}
+}
+
+// checkContentLength compares the Content-Length header of m, if any, with the
+// length of its body, which may have been stored with LF or CRLF line endings.
+func checkContentLength(m *message) string {
+	v := strings.TrimSpace(m.header.Get("Content-Length"))
+	if v == "" {
+		return ""
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Sprintf("invalid Content-Length %q", v)
+	}
+
+	body := m.data
+	if i := bytes.Index(body, []byte("\n\n")); i >= 0 {
+		body = body[i+2:]
+	} else {
+		body = nil
+	}
+
+	if lf := len(body); n != lf && n != lf+bytes.Count(body, []byte("\n")) {
+		return fmt.Sprintf("Content-Length is %d, body has %d bytes", n, lf)
+	}
+
+	return ""
+}
+
+type senderCount struct {
+	Sender   string `json:"sender"`
+	Messages int    `json:"messages"`
+}
+
+type statsResult struct {
+	Messages    int            `json:"messages"`
+	Bytes       int64          `json:"bytes"`
+	MinSize     int            `json:"min_size"`
+	MaxSize     int            `json:"max_size"`
+	AverageSize int64          `json:"average_size"`
+	FirstDate   string         `json:"first_date"`
+	LastDate    string         `json:"last_date"`
+	Years       map[string]int `json:"years"`
+	TopSenders  []senderCount  `json:"top_senders"`
+}
+
+func setupStats(fs *flag.FlagSet) runFunc {
+	top := fs.Int("top", 10, "number of top senders to list")
+
+	return func(c *cmdEnv, args []string) error {
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		res := statsResult{Years: map[string]int{}, TopSenders: []senderCount{}}
+		var first, last time.Time
+		senders := map[string]int{}
+
+		err = c.forEach(in, func(m *message) error {
+			size := len(m.data)
+			if res.Messages == 0 || size < res.MinSize {
+				res.MinSize = size
+			}
+			res.MaxSize = max(res.MaxSize, size)
+			res.Messages++
+			res.Bytes += int64(size)
+
+			if t := m.date(); !t.IsZero() {
+				if first.IsZero() || t.Before(first) {
+					first = t
+				}
+				if t.After(last) {
+					last = t
+				}
+				res.Years[strconv.Itoa(t.Year())]++
+			}
+
+			if s := m.sender(); s != "" {
+				senders[s]++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if res.Messages > 0 {
+			res.AverageSize = res.Bytes / int64(res.Messages)
+		}
+		res.FirstDate, res.LastDate = formatDate(first), formatDate(last)
+
+		for _, s := range sortedKeys(senders) {
+			res.TopSenders = append(res.TopSenders, senderCount{s, senders[s]})
+		}
+		sort.SliceStable(res.TopSenders, func(i, j int) bool {
+			return res.TopSenders[i].Messages > res.TopSenders[j].Messages
+		})
+		if len(res.TopSenders) > *top {
+			res.TopSenders = res.TopSenders[:max(*top, 0)]
+		}
+
+		if c.json {
+			return c.report(c.stdout, res, "")
+		}
+
+		fmt.Fprintf(c.stdout, "messages:  %d\n", res.Messages)
+		fmt.Fprintf(c.stdout, "bytes:     %d\n", res.Bytes)
+		fmt.Fprintf(c.stdout, "size:      min %d, max %d, average %d\n", res.MinSize, res.MaxSize, res.AverageSize)
+		fmt.Fprintf(c.stdout, "dates:     %s to %s\n", res.FirstDate, res.LastDate)
+		for _, y := range sortedKeys(res.Years) {
+			fmt.Fprintf(c.stdout, "year %s: %d\n", y, res.Years[y])
+		}
+		for _, s := range res.TopSenders {
+			fmt.Fprintf(c.stdout, "sender %s: %d\n", s.Sender, s.Messages)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,250 @@
+// Command mbox inspects and transforms mbox files.
+//
+// Usage:
+//
+//	mbox <command> [flags] [arguments]
+//
+// Commands read the mbox named by their file argument, or standard input if
+// it is "-" or missing, and write to standard output unless told otherwise.
+// Flags come before the arguments. The --format flag selects the mbox variant
+// (mboxrd, mboxo, mboxcl or mboxcl2) and --json prints results as JSON: a
+// single object, or for ls one object per line. Commands writing an mbox to
+// standard output print their results to standard error instead.
+//
+// Run "mbox help <command>" for the flags of a command.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/attilabuti/mbox"
+)
+
+// errProblems reports that a command found problems it has already printed,
+// so that only the exit status is left to set.
+var errProblems = errors.New("problems found")
+
+// runFunc runs a command with the arguments left after its flags.
+type runFunc func(c *cmdEnv, args []string) error
+
+type command struct {
+	name  string
+	args  string
+	short string
+	// setup defines the flags of the command and returns the function
+	// running it.
+	setup func(fs *flag.FlagSet) runFunc
+}
+
+var commands = []*command{
+	{"count", "[file]", "print the number of messages", setupCount},
+	{"ls", "[file]", "list the index, offset, date, sender and subject of every message", setupLs},
+	{"cat", "N [file]", "print message N (zero-based), unescaped", setupCat},
+	{"extract", "-d dir [file]", "write every message to its own .eml file", setupExtract},
+	{"split", "-d dir [file]", "split into several mbox files", setupSplit},
+	{"merge", "file...", "merge several mbox files into one", setupMerge},
+	{"validate", "[file]", "check the structure of every message", setupValidate},
+	{"repair", "[file]", "rewrite an mbox, fixing separators, escaping and blank lines", setupRepair},
+	{"convert", "--to variant [file]", "convert to another variant", setupConvert},
+	{"stats", "[file]", "print message counts, sizes, dates and top senders", setupStats},
+}
+
+// cmdEnv holds the streams and common flags of a command.
+type cmdEnv struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	format  string
+	variant mbox.Variant
+	json    bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit status: 0 on success,
+// 1 on errors or problems found, 2 on usage errors.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				fs, _ := newFlagSet(cmd, &cmdEnv{}, stdout)
+				fs.Usage()
+				return 0
+			}
+		}
+		usage(stdout)
+		return 0
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(stderr, "mbox: unknown command %q\n", name)
+		usage(stderr)
+		return 2
+	}
+
+	c := &cmdEnv{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs, runCmd := newFlagSet(cmd, c, stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	v, err := mbox.ParseVariant(c.format)
+	if err != nil {
+		fmt.Fprintf(stderr, "mbox %s: %v\n", name, err)
+		return 2
+	}
+	c.variant = v
+
+	if err := runCmd(c, fs.Args()); err != nil {
+		var uerr usageError
+		switch {
+		case errors.As(err, &uerr):
+			fmt.Fprintf(stderr, "mbox %s: %v\n", name, err)
+			fs.Usage()
+			return 2
+		case err != errProblems:
+			fmt.Fprintf(stderr, "mbox %s: %v\n", name, err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+// newFlagSet returns the flag set of cmd, with the common flags stored in c,
+// and the function running cmd.
+func newFlagSet(cmd *command, c *cmdEnv, out io.Writer) (*flag.FlagSet, runFunc) {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&c.format, "format", mbox.Mboxrd.String(), "mbox `variant`: mboxrd, mboxo, mboxcl or mboxcl2")
+	fs.BoolVar(&c.json, "json", false, "print results as JSON")
+	runCmd := cmd.setup(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(out, "usage: mbox %s [flags] %s\n\n%s.\n\nflags:\n", cmd.name, cmd.args, capitalize(cmd.short))
+		fs.PrintDefaults()
+	}
+
+	return fs, runCmd
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: mbox <command> [flags] [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "\nRun \"mbox help <command>\" for the flags of a command.\n")
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// usageError is an error in the arguments of a command.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// openInput opens the file named by the only remaining argument, or standard
+// input if there is none or it is "-".
+func (c *cmdEnv) openInput(args []string) (io.ReadCloser, error) {
+	if len(args) > 1 {
+		return nil, usageError("too many arguments")
+	}
+	if len(args) == 0 || args[0] == "-" {
+		return io.NopCloser(c.stdin), nil
+	}
+
+	return os.Open(args[0])
+}
+
+// openOutput creates the file at path, or returns standard output if path is
+// empty or "-".
+func (c *cmdEnv) openOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopWriteCloser{c.stdout}, nil
+	}
+
+	return os.Create(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// report prints the result of a command to w: v as a line of JSON with
+// --json, or else the text formatted with format and args.
+func (c *cmdEnv) report(w io.Writer, v any, format string, args ...any) error {
+	if c.json {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	_, err := fmt.Fprintf(w, format, args...)
+
+	return err
+}
+
+// reportTo returns where commands writing an mbox to the output path print
+// their results.
+func (c *cmdEnv) reportTo(output string) io.Writer {
+	if output == "" || output == "-" {
+		return c.stderr
+	}
+
+	return c.stdout
+}
+
+// closeOutput closes w, returning err if it is not nil.
+func closeOutput(w io.Closer, err error) error {
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
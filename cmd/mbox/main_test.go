@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMbox = `From alice@example.com Thu Jan  1 00:00:01 2015
+From: Alice <alice@example.com>
+Date: Thu, 01 Jan 2015 00:00:01 +0000
+Message-ID: <1@example.com>
+Subject: First
+
+>From the start.
+
+From bob@example.org Fri Jan  1 00:00:01 2016
+From: Bob <bob@example.org>
+Date: Fri, 01 Jan 2016 00:00:01 +0000
+Message-ID: <2@example.com>
+Subject: =?UTF-8?Q?Second_=C3=A9?=
+
+Second.
+
+From alice@example.com Sat Jan  2 00:00:01 2016
+From: Alice <alice@example.com>
+Date: Sat, 02 Jan 2016 00:00:01 +0000
+Message-ID: <3@example.com>
+Subject: Third
+
+Third.
+`
+
+// runTest runs a command line with stdin and returns its exit status and
+// outputs.
+func runTest(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func writeTestMbox(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.mbox")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCount(t *testing.T) {
+	path := writeTestMbox(t, testMbox)
+
+	for _, args := range [][]string{{"count", path}, {"count"}, {"count", "-"}} {
+		if code, out, errOut := runTest(t, testMbox, args...); code != 0 || out != "3\n" {
+			t.Errorf("%v - Unexpected result %d, %q, %q", args, code, out, errOut)
+		}
+	}
+
+	if code, out, _ := runTest(t, "", "count", "--json", path); code != 0 || out != "{\"messages\":3}\n" {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+}
+
+func TestLs(t *testing.T) {
+	code, out, _ := runTest(t, testMbox, "ls")
+	if code != 0 {
+		t.Fatalf("Exit status %d", code)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if want := "1\t180\t2016-01-01T00:00:01Z\tBob <bob@example.org>\tSecond é"; len(lines) != 3 || lines[1] != want {
+		t.Errorf("Expected second line %q, got:\n%s", want, out)
+	}
+
+	code, out, _ = runTest(t, testMbox, "ls", "--json")
+	if code != 0 {
+		t.Fatalf("Exit status %d", code)
+	}
+
+	var e listEntry
+	if err := json.Unmarshal([]byte(strings.Split(out, "\n")[2]), &e); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if want := (listEntry{2, 365, "2016-01-02T00:00:01Z", "Alice <alice@example.com>", "Third"}); e != want {
+		t.Errorf("Expected %+v, got %+v", want, e)
+	}
+}
+
+func TestCat(t *testing.T) {
+	code, out, _ := runTest(t, testMbox, "cat", "0")
+	if want := "From: Alice <alice@example.com>\nDate: Thu, 01 Jan 2015 00:00:01 +0000\nMessage-ID: <1@example.com>\nSubject: First\n\nFrom the start.\n"; code != 0 || out != want {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+
+	if code, out, _ := runTest(t, testMbox, "cat", "--format", "mboxcl2", "0"); code != 0 || !strings.Contains(out, "\n>From the start.\n") {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+
+	if code, _, errOut := runTest(t, testMbox, "cat", "3"); code != 1 || !strings.Contains(errOut, "no message 3") {
+		t.Errorf("Unexpected result %d, %q", code, errOut)
+	}
+	if code, _, _ := runTest(t, testMbox, "cat", "x"); code != 2 {
+		t.Errorf("Expected exit status 2, got %d", code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if code, out, _ := runTest(t, testMbox, "validate"); code != 0 || out != "3 messages, 0 problems\n" {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+
+	broken := strings.Replace(testMbox, "From bob@example.org Fri Jan  1 00:00:01 2016", "From bob@example.org yesterday", 1)
+	code, out, _ := runTest(t, broken, "validate", "--json")
+	if code != 1 {
+		t.Errorf("Expected exit status 1, got %d", code)
+	}
+
+	var res validateResult
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if res.Messages != 3 || res.Valid || len(res.Problems) != 1 || res.Problems[0].Index != 1 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+
+	if code, out, _ := runTest(t, "Subject: x\n\nno separator\n", "validate"); code != 1 || !strings.Contains(out, "data before the first separator line") {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+
+	mboxcl := "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nContent-Length: 3\nSubject: x\n\nHello.\n"
+	if code, out, _ := runTest(t, mboxcl, "validate", "--format", "mboxcl"); code != 1 || !strings.Contains(out, "Content-Length is 3, body has 7 bytes") {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	broken := "\nFrom: Carol <carol@example.com>\nDate: Wed, 31 Dec 2014 00:00:01 +0000\nSubject: Zero\n\nNo separator.\n" + testMbox
+	code, out, errOut := runTest(t, broken, "repair")
+	if code != 0 || errOut != "4 messages, 1 separators fixed\n" {
+		t.Fatalf("Unexpected result %d, %q", code, errOut)
+	}
+	if !strings.HasPrefix(out, "From carol@example.com Wed Dec 31 00:00:01 2014\n") || !strings.Contains(out, "\n>From the start.\n") {
+		t.Errorf("Unexpected output:\n%s", out)
+	}
+
+	if code, out, _ := runTest(t, out, "validate"); code != 0 {
+		t.Errorf("Repaired mbox is invalid:\n%s", out)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.mbox")
+	code, out, _ := runTest(t, testMbox, "convert", "--to", "mboxcl2", "--json", "-o", output)
+	if code != 0 || out != "{\"messages\":3}\n" {
+		t.Fatalf("Unexpected result %d, %q", code, out)
+	}
+
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "Content-Length: 16\n") || !strings.Contains(string(b), "\nFrom the start.\n") {
+		t.Errorf("Unexpected output:\n%s", b)
+	}
+
+	if code, _, _ := runTest(t, testMbox, "convert"); code != 2 {
+		t.Errorf("Expected exit status 2, got %d", code)
+	}
+}
+
+func TestSplitAndMerge(t *testing.T) {
+	dir := t.TempDir()
+	code, out, _ := runTest(t, testMbox, "split", "-d", dir, "--by", "year", "--json")
+	if code != 0 {
+		t.Fatalf("Exit status %d", code)
+	}
+
+	var split struct{ Files []string }
+	if err := json.Unmarshal([]byte(out), &split); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if want := []string{"2015-0001.mbox", "2016-0001.mbox"}; !reflect.DeepEqual(split.Files, want) {
+		t.Errorf("Expected %v, got %v", want, split.Files)
+	}
+
+	var paths []string
+	for _, f := range split.Files {
+		paths = append(paths, filepath.Join(dir, f))
+	}
+
+	// The duplicate on standard input is dropped.
+	args := append([]string{"merge", "--by-date", "--dedup", "message-id"}, paths[1], paths[0], "-")
+	code, out, errOut := runTest(t, testMbox[:strings.Index(testMbox, "From bob")], args...)
+	if code != 0 {
+		t.Fatalf("Exit status %d: %s", code, errOut)
+	}
+	if out != testMbox+"\n" {
+		t.Errorf("Expected:\n%s\ngot:\n%s", testMbox, out)
+	}
+	if want := paths[1] + ": 2 messages, 0 duplicates\n" + paths[0] + ": 1 messages, 0 duplicates\n-: 0 messages, 1 duplicates\n"; errOut != want {
+		t.Errorf("Expected summary %q, got %q", want, errOut)
+	}
+}
+
+func TestExtractAndStats(t *testing.T) {
+	dir := t.TempDir()
+	if code, out, _ := runTest(t, testMbox, "extract", "-d", dir); code != 0 || out != "3 files written\n" {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 3 {
+		t.Errorf("Expected 3 files, got %v", files)
+	}
+
+	code, out, _ := runTest(t, testMbox, "stats", "--json", "--top", "1")
+	if code != 0 {
+		t.Fatalf("Exit status %d", code)
+	}
+
+	var res statsResult
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	want := statsResult{
+		Messages:  3,
+		FirstDate: "2015-01-01T00:00:01Z",
+		LastDate:  "2016-01-02T00:00:01Z",
+		Years:     map[string]int{"2015": 1, "2016": 2},
+		TopSenders: []senderCount{
+			{"alice@example.com", 2},
+		},
+	}
+	res.Bytes, res.MinSize, res.MaxSize, res.AverageSize = 0, 0, 0, 0
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("Expected %+v, got %+v", want, res)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if code, _, errOut := runTest(t, "", "frobnicate"); code != 2 || !strings.Contains(errOut, "unknown command") {
+		t.Errorf("Unexpected result %d, %q", code, errOut)
+	}
+	if code, _, _ := runTest(t, "", "count", "--format", "mmdf"); code != 2 {
+		t.Errorf("Expected exit status 2, got %d", code)
+	}
+	if code, out, _ := runTest(t, "", "help", "split"); code != 0 || !strings.Contains(out, "usage: mbox split") {
+		t.Errorf("Unexpected result %d, %q", code, out)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/attilabuti/mbox"
+)
+
+func setupExtract(fs *flag.FlagSet) runFunc {
+	dir := fs.String("d", "", "output `directory`")
+	name := fs.String("name", mbox.DefaultExportName, "file name `template`")
+	crlf := fs.Bool("crlf", false, "write CRLF line endings")
+	manifest := fs.String("manifest", "", "write a CSV manifest of the files to `file`")
+
+	return func(c *cmdEnv, args []string) error {
+		if *dir == "" {
+			return usageError("missing output directory")
+		}
+
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		opts := &mbox.ExportOptions{Name: *name, Variant: c.variant, CRLF: *crlf}
+		if *manifest != "" {
+			f, err := os.Create(*manifest)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			opts.Manifest = f
+		}
+
+		n, err := mbox.ExportEML(mbox.NewReader(in), *dir, opts)
+		if err != nil {
+			return err
+		}
+
+		return c.report(c.stdout, map[string]int{"files": n}, "%d files written\n", n)
+	}
+}
+
+func setupSplit(fs *flag.FlagSet) runFunc {
+	dir := fs.String("d", "", "output `directory`")
+	maxBytes := fs.Int64("max-bytes", 0, "maximum size of a file in `bytes`")
+	maxMessages := fs.Int("max-messages", 0, "maximum number of messages per file")
+	by := fs.String("by", "", "group messages by `key`: year, month, domain or header:Name")
+	name := fs.String("name", mbox.DefaultSplitName, "file name `template`")
+
+	return func(c *cmdEnv, args []string) error {
+		if *dir == "" {
+			return usageError("missing output directory")
+		}
+
+		opts := &mbox.SplitOptions{MaxBytes: *maxBytes, MaxMessages: *maxMessages, Name: *name, Variant: c.variant}
+		switch {
+		case *by == "":
+		case *by == "year":
+			opts.Key = mbox.SplitByYear
+		case *by == "month":
+			opts.Key = mbox.SplitByMonth
+		case *by == "domain":
+			opts.Key = mbox.SplitBySenderDomain
+		case strings.HasPrefix(*by, "header:") && len(*by) > len("header:"):
+			opts.Key = mbox.SplitByHeader(strings.TrimPrefix(*by, "header:"))
+		default:
+			return usageError(fmt.Sprintf("invalid split key %q", *by))
+		}
+
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		files, err := mbox.Split(mbox.NewReader(in), *dir, opts)
+		if err != nil {
+			return err
+		}
+
+		if c.json {
+			return c.report(c.stdout, map[string][]string{"files": files}, "")
+		}
+		for _, f := range files {
+			fmt.Fprintln(c.stdout, f)
+		}
+
+		return nil
+	}
+}
+
+func setupMerge(fs *flag.FlagSet) runFunc {
+	output := fs.String("o", "", "output `file` (default standard output)")
+	to := fs.String("to", "", "output `variant` (default the input variant)")
+	byDate := fs.Bool("by-date", false, "interleave the inputs by date; each must be in date order")
+	dedup := fs.String("dedup", "", "drop duplicates by `key`: message-id or content")
+
+	return func(c *cmdEnv, args []string) error {
+		if len(args) == 0 {
+			return usageError("missing input files")
+		}
+
+		opts := &mbox.MergeOptions{Variant: c.variant, ByDate: *byDate}
+		if *to != "" {
+			v, err := mbox.ParseVariant(*to)
+			if err != nil {
+				return usageError(err.Error())
+			}
+			opts.Variant = v
+		}
+		switch *dedup {
+		case "":
+		case "message-id":
+			opts.Dedup = mbox.MessageIDKey
+		case "content":
+			opts.Dedup = mbox.ContentKey
+		default:
+			return usageError(fmt.Sprintf("invalid dedup key %q", *dedup))
+		}
+
+		inputs := make([]mbox.MergeInput, len(args))
+		for i, name := range args {
+			in, err := c.openInput([]string{name})
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+
+			inputs[i] = mbox.MergeInput{Name: name, R: in, Variant: c.variant}
+		}
+
+		out, err := c.openOutput(*output)
+		if err != nil {
+			return err
+		}
+
+		summary, err := mbox.Merge(out, inputs, opts)
+		if err = closeOutput(out, err); err != nil {
+			return err
+		}
+
+		w := c.reportTo(*output)
+		if c.json {
+			return c.report(w, map[string][]mbox.MergeSummary{"inputs": summary}, "")
+		}
+		for _, s := range summary {
+			fmt.Fprintf(w, "%s: %d messages, %d duplicates\n", s.Name, s.Messages, s.Duplicates)
+		}
+
+		return nil
+	}
+}
+
+func setupConvert(fs *flag.FlagSet) runFunc {
+	output := fs.String("o", "", "output `file` (default standard output)")
+	to := fs.String("to", "", "output `variant`")
+
+	return func(c *cmdEnv, args []string) error {
+		if *to == "" {
+			return usageError("missing output variant")
+		}
+		v, err := mbox.ParseVariant(*to)
+		if err != nil {
+			return usageError(err.Error())
+		}
+
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := c.openOutput(*output)
+		if err != nil {
+			return err
+		}
+
+		summary, err := mbox.Merge(out, []mbox.MergeInput{{R: in, Variant: c.variant}}, &mbox.MergeOptions{Variant: v})
+		if err = closeOutput(out, err); err != nil {
+			return err
+		}
+
+		n := summary[0].Messages
+
+		return c.report(c.reportTo(*output), map[string]int{"messages": n}, "%d messages converted\n", n)
+	}
+}
+
+type repairResult struct {
+	Messages        int `json:"messages"`
+	SeparatorsFixed int `json:"separators_fixed"`
+}
+
+func setupRepair(fs *flag.FlagSet) runFunc {
+	output := fs.String("o", "", "output `file` (default standard output)")
+
+	return func(c *cmdEnv, args []string) error {
+		in, err := c.openInput(args)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		// A message at the start of the data without a separator line gets
+		// an empty one, which is replaced below.
+		br := bufio.NewReader(in)
+		for {
+			if b, err := br.Peek(1); err != nil || (b[0] != '\r' && b[0] != '\n') {
+				break
+			}
+			br.ReadByte()
+		}
+
+		var src io.Reader = br
+		if b, _ := br.Peek(5); len(b) > 0 && string(b) != "From " {
+			src = io.MultiReader(strings.NewReader("From \n"), br)
+		}
+
+		out, err := c.openOutput(*output)
+		if err != nil {
+			return err
+		}
+
+		var res repairResult
+		w := mbox.NewVariantWriter(out, c.variant)
+		err = c.forEach(src, func(m *message) error {
+			res.Messages++
+
+			from, t, err := mbox.ParseSeparator(m.sep)
+			if err != nil {
+				res.SeparatorsFixed++
+				from, t = "", m.date()
+				if addr, err := mail.ParseAddress(m.header.Get("From")); err == nil {
+					from = addr.Address
+				}
+			}
+
+			return w.WriteMessage(from, t, bytes.NewReader(c.variant.Unescape(m.data)))
+		})
+		if err == nil {
+			err = w.Close()
+		}
+		if err = closeOutput(out, err); err != nil {
+			return err
+		}
+
+		return c.report(c.reportTo(*output), res, "%d messages, %d separators fixed\n", res.Messages, res.SeparatorsFixed)
+	}
+}
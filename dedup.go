@@ -0,0 +1,478 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultMaxMemoryKeys is the number of message keys a Deduper keeps in memory
+// before spilling them to a temporary file.
+const DefaultMaxMemoryKeys = 1 << 20
+
+var (
+	ErrNotSeekable = errors.New("reader is not seekable")
+
+	reEscapedFrom = regexp.MustCompile(`^>+From `)
+)
+
+// DedupKeyFunc returns the key identifying a message for deduplication.
+// Messages with an empty key are never treated as duplicates.
+type DedupKeyFunc func(msg []byte) (string, error)
+
+// Duplicate describes a message dropped by a Deduper.
+type Duplicate struct {
+	// Index is the position of the dropped message in the input.
+	Index int
+	// KeptIndex is the position of the message kept in its place.
+	KeptIndex int
+	// Key is the deduplication key shared by both messages.
+	Key string
+}
+
+// DedupOptions configures a Deduper.
+type DedupOptions struct {
+	// Key computes message keys. It defaults to MessageIDKey.
+	Key DedupKeyFunc
+
+	// KeepLast keeps the last copy of a message instead of the first. It
+	// requires two passes over the input, which must be an io.ReadSeeker.
+	KeepLast bool
+
+	// OnDuplicate, if non-nil, is called for every dropped message.
+	OnDuplicate func(Duplicate)
+
+	// MaxMemoryKeys bounds the number of keys held in memory; beyond it keys
+	// are kept in a temporary file in TempDir. It defaults to
+	// DefaultMaxMemoryKeys.
+	MaxMemoryKeys int
+
+	// TempDir is the directory for the spilled key table. It defaults to
+	// os.TempDir().
+	TempDir string
+}
+
+// Deduper filters repeated messages out of an mbox stream.
+type Deduper struct {
+	r     *Reader
+	opts  DedupOptions
+	keys  *keyTable
+	index int
+	count int
+}
+
+// NewDeduper returns a Deduper reading mbox data from r. When opts.KeepLast is
+// set, r must implement io.ReadSeeker; it is read once to find the last copy
+// of every message and then rewound. A nil opts is the same as the zero value.
+func NewDeduper(r io.Reader, opts *DedupOptions) (*Deduper, error) {
+	d := &Deduper{}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.Key == nil {
+		d.opts.Key = MessageIDKey
+	}
+	if d.opts.MaxMemoryKeys <= 0 {
+		d.opts.MaxMemoryKeys = DefaultMaxMemoryKeys
+	}
+
+	d.keys = &keyTable{mem: map[keyDigest]int{}, max: d.opts.MaxMemoryKeys, dir: d.opts.TempDir}
+
+	if d.opts.KeepLast {
+		rs, ok := r.(io.ReadSeeker)
+		if !ok {
+			return nil, ErrNotSeekable
+		}
+
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.scanLast(NewReader(rs)); err != nil {
+			d.Close()
+			return nil, err
+		}
+
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	d.r = NewReader(r)
+
+	return d, nil
+}
+
+// scanLast records the index of the last message carrying each key.
+func (d *Deduper) scanLast(r *Reader) error {
+	for i := 0; ; i++ {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return err
+		}
+
+		key, err := d.opts.Key(b)
+		if err != nil {
+			return err
+		}
+
+		if key != "" {
+			if err := d.keys.put(digestKey(key), i); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// NextMessage returns the next message that is not a duplicate of a message
+// kept before (or, with KeepLast, after) it. It will return io.EOF if there
+// are no messages left.
+func (d *Deduper) NextMessage() (io.Reader, error) {
+	for {
+		msg, err := d.r.NextMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		index := d.index
+		d.index++
+
+		key, err := d.opts.Key(b)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return bytes.NewReader(b), nil
+		}
+
+		k := digestKey(key)
+		kept, found, err := d.keys.get(k)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !found:
+			if err := d.keys.put(k, index); err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(b), nil
+		case d.opts.KeepLast && kept == index:
+			return bytes.NewReader(b), nil
+		}
+
+		d.count++
+		if d.opts.OnDuplicate != nil {
+			d.opts.OnDuplicate(Duplicate{Index: index, KeptIndex: kept, Key: key})
+		}
+	}
+}
+
+// Duplicates returns the number of messages dropped so far.
+func (d *Deduper) Duplicates() int {
+	return d.count
+}
+
+// Close releases the temporary key table, if one was created.
+func (d *Deduper) Close() error {
+	return d.keys.close()
+}
+
+// MessageIDKey keys a message by its Message-ID header, falling back to
+// ContentKey for messages without one or with a malformed header.
+func MessageIDKey(msg []byte) (string, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return rawKey(msg)
+	}
+
+	if id := strings.Trim(strings.TrimSpace(m.Header.Get("Message-Id")), "<>"); id != "" {
+		return "<" + id + ">", nil
+	}
+
+	return contentKey(m)
+}
+
+// ContentKey keys a message by a hash of its normalized content: the
+// originator, recipient, date and subject headers and the body with line
+// endings, trailing whitespace and mbox From escaping removed. Headers added
+// by mail clients and exporters (status flags, labels) do not affect it.
+// Messages with a malformed header are keyed by a hash of the whole message,
+// normalized in the same way as the body.
+func ContentKey(msg []byte) (string, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return rawKey(msg)
+	}
+
+	return contentKey(m)
+}
+
+func contentKey(m *mail.Message) (string, error) {
+	h := sha256.New()
+	for _, name := range []string{"From", "To", "Cc", "Date", "Subject"} {
+		io.WriteString(h, name+":"+strings.Join(strings.Fields(m.Header.Get(name)), " ")+"\n")
+	}
+	io.WriteString(h, "\n")
+
+	if err := hashLines(h, m.Body); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rawKey keys a message which cannot be parsed by a hash of its normalized
+// lines.
+func rawKey(msg []byte) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, "raw\n")
+	if err := hashLines(h, bytes.NewReader(msg)); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashLines writes the lines of r to h without line endings, trailing
+// whitespace, mbox From escaping and trailing blank lines.
+func hashLines(h io.Writer, r io.Reader) error {
+	var blank int
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<24)
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), " \t\r")
+		if reEscapedFrom.MatchString(l) {
+			l = l[1:]
+		}
+
+		// Trailing blank lines differ between mbox writers, so only emit
+		// them once they are followed by more text.
+		if l == "" {
+			blank++
+			continue
+		}
+		for ; blank > 0; blank-- {
+			io.WriteString(h, "\n")
+		}
+		io.WriteString(h, l+"\n")
+	}
+
+	return s.Err()
+}
+
+// keyDigest is the fixed-size form in which message keys are stored.
+type keyDigest [16]byte
+
+func digestKey(key string) keyDigest {
+	var k keyDigest
+	sum := sha256.Sum256([]byte(key))
+	copy(k[:], sum[:])
+
+	return k
+}
+
+// keyTable maps key digests to message indices. It starts in memory and moves
+// to an open-addressing hash table in a temporary file once it holds more than
+// max keys, so memory use stays bounded for arbitrarily large archives.
+type keyTable struct {
+	mem  map[keyDigest]int
+	max  int
+	dir  string
+	disk *diskTable
+}
+
+func (t *keyTable) get(k keyDigest) (int, bool, error) {
+	if t.disk != nil {
+		return t.disk.get(k)
+	}
+
+	i, ok := t.mem[k]
+
+	return i, ok, nil
+}
+
+func (t *keyTable) put(k keyDigest, index int) error {
+	if t.disk != nil {
+		return t.disk.put(k, index)
+	}
+
+	t.mem[k] = index
+	if len(t.mem) <= t.max {
+		return nil
+	}
+
+	disk, err := newDiskTable(t.dir, uint64(len(t.mem))*2)
+	if err != nil {
+		return err
+	}
+	for k, i := range t.mem {
+		if err := disk.put(k, i); err != nil {
+			disk.close()
+			return err
+		}
+	}
+
+	t.mem = nil
+	t.disk = disk
+
+	return nil
+}
+
+func (t *keyTable) close() error {
+	if t.disk == nil {
+		return nil
+	}
+
+	return t.disk.close()
+}
+
+// diskSlotSize is the size of a diskTable slot: the key digest followed by the
+// message index plus one, so that an all-zero slot is empty.
+const diskSlotSize = len(keyDigest{}) + 8
+
+type diskTable struct {
+	f     *os.File
+	slots uint64
+	count uint64
+}
+
+func newDiskTable(dir string, capacity uint64) (*diskTable, error) {
+	f, err := os.CreateTemp(dir, "mbox-dedup-*")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &diskTable{f: f, slots: 1024}
+	for t.slots < capacity {
+		t.slots <<= 1
+	}
+
+	if err := f.Truncate(int64(t.slots) * int64(diskSlotSize)); err != nil {
+		t.close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// find returns the slot holding k, or the empty slot where it belongs.
+func (t *diskTable) find(k keyDigest) (uint64, int, bool, error) {
+	var buf [diskSlotSize]byte
+
+	slot := binary.LittleEndian.Uint64(k[:8]) & (t.slots - 1)
+	for {
+		if _, err := t.f.ReadAt(buf[:], int64(slot)*int64(diskSlotSize)); err != nil {
+			return 0, 0, false, err
+		}
+
+		v := binary.LittleEndian.Uint64(buf[len(k):])
+		if v == 0 {
+			return slot, 0, false, nil
+		}
+		if bytes.Equal(buf[:len(k)], k[:]) {
+			return slot, int(v - 1), true, nil
+		}
+
+		slot = (slot + 1) & (t.slots - 1)
+	}
+}
+
+func (t *diskTable) get(k keyDigest) (int, bool, error) {
+	_, index, ok, err := t.find(k)
+
+	return index, ok, err
+}
+
+func (t *diskTable) put(k keyDigest, index int) error {
+	if (t.count+1)*4 > t.slots*3 {
+		if err := t.grow(); err != nil {
+			return err
+		}
+	}
+
+	slot, _, ok, err := t.find(k)
+	if err != nil {
+		return err
+	}
+
+	var buf [diskSlotSize]byte
+	copy(buf[:], k[:])
+	binary.LittleEndian.PutUint64(buf[len(k):], uint64(index)+1)
+	if _, err := t.f.WriteAt(buf[:], int64(slot)*int64(diskSlotSize)); err != nil {
+		return err
+	}
+
+	if !ok {
+		t.count++
+	}
+
+	return nil
+}
+
+// grow rehashes the table into a new file twice the size.
+func (t *diskTable) grow() error {
+	n, err := newDiskTable(filepath.Dir(t.f.Name()), t.slots*2)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(io.NewSectionReader(t.f, 0, int64(t.slots)*int64(diskSlotSize)), 1<<16)
+	var buf [diskSlotSize]byte
+	for i := uint64(0); i < t.slots; i++ {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			n.close()
+			return err
+		}
+
+		v := binary.LittleEndian.Uint64(buf[len(keyDigest{}):])
+		if v == 0 {
+			continue
+		}
+
+		var k keyDigest
+		copy(k[:], buf[:])
+		if err := n.put(k, int(v-1)); err != nil {
+			n.close()
+			return err
+		}
+	}
+
+	t.close()
+	*t = *n
+
+	return nil
+}
+
+func (t *diskTable) close() error {
+	name := t.f.Name()
+	err := t.f.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+
+	return err
+}
@@ -0,0 +1,151 @@
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const mboxWithDuplicates = `From a@example.com Thu Jan  1 00:00:01 2015
+From: a@example.com
+Message-ID: <1@example.com>
+Subject: One
+
+First copy.
+
+From a@example.com Thu Jan  1 00:00:02 2015
+From: a@example.com
+Subject: No ID
+
+Hello there.
+
+From a@example.com Thu Jan  1 00:00:03 2015
+From: a@example.com
+Message-ID: <1@example.com>
+X-Gmail-Labels: Inbox
+Subject: One
+
+Second copy.
+
+From a@example.com Thu Jan  1 00:00:04 2015
+From: a@example.com
+X-Status: F
+Subject: No ID
+
+Hello there.   
+
+
+`
+
+func dedupSubjects(t *testing.T, d *Deduper) []string {
+	var bodies []string
+	for {
+		r, err := d.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		text, err := TextBody(r, nil)
+		if err != nil {
+			t.Fatalf("TextBody() = %v", err)
+		}
+		bodies = append(bodies, text)
+	}
+
+	return bodies
+}
+
+func TestDeduper(t *testing.T) {
+	for _, max := range []int{0, 1} {
+		var dups []Duplicate
+		d, err := NewDeduper(strings.NewReader(mboxWithDuplicates), &DedupOptions{
+			MaxMemoryKeys: max,
+			TempDir:       t.TempDir(),
+			OnDuplicate:   func(dup Duplicate) { dups = append(dups, dup) },
+		})
+		if err != nil {
+			t.Fatalf("NewDeduper() = %v", err)
+		}
+
+		got := strings.Join(dedupSubjects(t, d), "|")
+		if want := "First copy.|Hello there."; got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+
+		if d.Duplicates() != 2 || len(dups) != 2 || dups[0] != (Duplicate{Index: 2, KeptIndex: 0, Key: "<1@example.com>"}) || dups[1].KeptIndex != 1 {
+			t.Errorf("Unexpected duplicates: %+v", dups)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+	}
+}
+
+func TestDeduperKeepLast(t *testing.T) {
+	d, err := NewDeduper(strings.NewReader(mboxWithDuplicates), &DedupOptions{KeepLast: true})
+	if err != nil {
+		t.Fatalf("NewDeduper() = %v", err)
+	}
+	defer d.Close()
+
+	got := strings.Join(dedupSubjects(t, d), "|")
+	if want := "Second copy.|Hello there."; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if _, err := NewDeduper(io.MultiReader(strings.NewReader(mboxWithDuplicates)), &DedupOptions{KeepLast: true}); err != ErrNotSeekable {
+		t.Errorf("Expected ErrNotSeekable, got %v", err)
+	}
+}
+
+func TestDeduperMalformedHeader(t *testing.T) {
+	const msg = "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: Broken\nnot a header\n\nBody.\n\n"
+	data := msg + strings.Replace(msg, "Body.\n", "Body.  \n\n", 1) + strings.Replace(msg, "Body.", "Other.", 1)
+
+	for _, key := range []DedupKeyFunc{MessageIDKey, ContentKey} {
+		d, err := NewDeduper(strings.NewReader(data), &DedupOptions{Key: key})
+		if err != nil {
+			t.Fatalf("NewDeduper() = %v", err)
+		}
+
+		n := 0
+		for {
+			_, err := d.NextMessage()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Unexpected error after NextMessage(): %v", err)
+			}
+			n++
+		}
+		d.Close()
+
+		if n != 2 || d.Duplicates() != 1 {
+			t.Errorf("Expected 2 messages and 1 duplicate, got %d and %d", n, d.Duplicates())
+		}
+	}
+}
+
+func TestDiskTableGrow(t *testing.T) {
+	tbl, err := newDiskTable(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("newDiskTable() = %v", err)
+	}
+	defer tbl.close()
+
+	for i := 0; i < 5000; i++ {
+		if err := tbl.put(digestKey(strings.Repeat("k", i%100)+string(rune(i))), i); err != nil {
+			t.Fatalf("put() = %v", err)
+		}
+	}
+
+	for i := 0; i < 5000; i += 37 {
+		got, ok, err := tbl.get(digestKey(strings.Repeat("k", i%100) + string(rune(i))))
+		if err != nil || !ok || got != i {
+			t.Fatalf("get(%d) = %d, %v, %v", i, got, ok, err)
+		}
+	}
+}
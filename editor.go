@@ -0,0 +1,412 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+)
+
+var ErrMailboxChanged = errors.New("mailbox changed since it was opened")
+
+// Editor modifies an mbox file in place: messages can be deleted or replaced,
+// and the changes are written by Commit.
+//
+// Commit writes the new mailbox to a temporary file in the same directory and
+// renames it over the original, so that readers see either the old or the new
+// mailbox. The data before the first changed message and the messages which
+// were not changed are copied byte for byte. Messages appended to the file
+// since it was opened are kept. The mailbox is locked from the time it is
+// opened until the Editor is closed, so that mail delivery agents wait for
+// Commit; it is locked again after the rename. The new file gets the
+// permissions, but not the owner, of the original.
+type Editor struct {
+	path    string
+	v       Variant
+	opts    EditorOptions
+	f       *os.File
+	lock    *MailboxLock
+	size    int64
+	msgs    []editorMessage
+	changes map[int]*editorChange
+}
+
+// EditorOptions configures an Editor.
+type EditorOptions struct {
+	// Lock configures the lock held on the mailbox. A nil Lock uses the
+	// defaults of Lock, with DefaultLockMethods. The methods should include
+	// LockDotlock, as fcntl and flock locks do not survive the rename done
+	// by Commit.
+	Lock *LockOptions
+
+	// NoLock edits the mailbox without locking it. It is only safe if no
+	// other process writes to the mailbox at the same time: a message
+	// delivered between the checks done by Commit and the rename would be
+	// lost.
+	NoLock bool
+}
+
+// editorMessage is the byte range of a message in the file, from its
+// separator line to the blank line ending it.
+type editorMessage struct {
+	start, end int64
+	sep        string
+}
+
+type editorChange struct {
+	deleted bool
+	data    []byte
+}
+
+// OpenEditor opens the mbox of variant v at path for editing, and locks it.
+// Messages are found as Reader finds them.
+func OpenEditor(path string, v Variant) (*Editor, error) {
+	return OpenEditorOptions(path, v, nil)
+}
+
+// OpenEditorOptions is like OpenEditor, with options. A nil opts is the same
+// as OpenEditor.
+func OpenEditorOptions(path string, v Variant, opts *EditorOptions) (*Editor, error) {
+	e := &Editor{path: path, v: v}
+	if opts != nil {
+		e.opts = *opts
+	}
+
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// load opens and locks the mailbox file, releasing the lock held on the
+// previous one, and finds its messages.
+func (e *Editor) load() error {
+	if err := e.unlock(); err != nil {
+		return err
+	}
+
+	flag := os.O_RDONLY
+	if !e.opts.NoLock {
+		// Exclusive fcntl locks need a file open for writing.
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(e.path, flag, 0)
+	if err != nil {
+		return err
+	}
+
+	var l *MailboxLock
+	if !e.opts.NoLock {
+		if l, err = Lock(f, e.opts.Lock); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	fail := func(err error) error {
+		if l != nil {
+			l.Unlock()
+		}
+		f.Close()
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	var msgs []editorMessage
+	r := NewReader(f)
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fail(err)
+		}
+
+		if _, err := io.Copy(io.Discard, msg); err != nil {
+			return fail(err)
+		}
+
+		if n := len(msgs); n > 0 {
+			msgs[n-1].end = r.Offset()
+		}
+		msgs = append(msgs, editorMessage{start: r.Offset(), end: info.Size(), sep: r.Separator()})
+	}
+
+	if e.f != nil {
+		e.f.Close()
+	}
+	e.f, e.lock, e.size, e.msgs, e.changes = f, l, info.Size(), msgs, map[int]*editorChange{}
+
+	return nil
+}
+
+// unlock releases the lock held on the mailbox, if any.
+func (e *Editor) unlock() error {
+	if e.lock == nil {
+		return nil
+	}
+
+	err := e.lock.Unlock()
+	e.lock = nil
+
+	return err
+}
+
+// Len returns the number of messages in the mailbox when it was opened or
+// last committed. Indexes passed to the other methods refer to this state
+// until Commit.
+func (e *Editor) Len() int {
+	return len(e.msgs)
+}
+
+func (e *Editor) check(i int) error {
+	if i < 0 || i >= len(e.msgs) {
+		return fmt.Errorf("message %d out of range [0, %d)", i, len(e.msgs))
+	}
+
+	return nil
+}
+
+// Separator returns the "From " separator line of message i.
+func (e *Editor) Separator(i int) (string, error) {
+	if err := e.check(i); err != nil {
+		return "", err
+	}
+
+	return e.msgs[i].sep, nil
+}
+
+// Message returns message i, with its header and unescaped body and LF line
+// endings, including changes made by Replace. It returns nil for a deleted
+// message.
+func (e *Editor) Message(i int) ([]byte, error) {
+	if err := e.check(i); err != nil {
+		return nil, err
+	}
+
+	if c, ok := e.changes[i]; ok {
+		return c.data, nil
+	}
+
+	m := e.msgs[i]
+	b := make([]byte, m.end-m.start)
+	if _, err := e.f.ReadAt(b, m.start); err != nil {
+		return nil, err
+	}
+
+	b = normalizeNewlines(b)
+	if j := bytes.IndexByte(b, '\n'); j >= 0 {
+		b = b[j+1:]
+	} else {
+		b = nil
+	}
+	b = bytes.TrimSuffix(b, []byte("\n\n"))
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+
+	header, body := splitMessage(b)
+
+	return joinMessage(header, e.v.unescape(body)), nil
+}
+
+// Flags returns the flags recorded in the header of message i.
+func (e *Editor) Flags(i int) (Flags, error) {
+	b, err := e.Message(i)
+	if err != nil || b == nil {
+		return 0, err
+	}
+
+	header, _ := splitMessage(b)
+	m, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+	if err != nil {
+		return 0, err
+	}
+
+	return HeaderFlags(m.Header), nil
+}
+
+// Delete marks message i for deletion.
+func (e *Editor) Delete(i int) error {
+	if err := e.check(i); err != nil {
+		return err
+	}
+
+	e.changes[i] = &editorChange{deleted: true}
+
+	return nil
+}
+
+// Replace replaces the header and body of message i with the message read
+// from r. The separator line is kept, and the body is escaped for the variant
+// of the mailbox.
+func (e *Editor) Replace(i int, r io.Reader) error {
+	if err := e.check(i); err != nil {
+		return err
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	e.changes[i] = &editorChange{data: normalizeNewlines(b)}
+
+	return nil
+}
+
+// SetFlags replaces the Status and X-Status fields of message i, and its
+// X-Mozilla-Status fields if present, to record f.
+func (e *Editor) SetFlags(i int, f Flags) error {
+	b, err := e.Message(i)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return nil
+	}
+
+	return e.Replace(i, bytes.NewReader(SetFlags(b, f)))
+}
+
+// Commit writes the changes to the mailbox file. Afterwards, the Editor
+// reflects the new file, and indexes are renumbered. It returns
+// ErrMailboxChanged if the file was truncated or rewritten by someone else.
+func (e *Editor) Commit() error {
+	if len(e.changes) == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	if err := e.checkUnchanged(info); err != nil {
+		return err
+	}
+
+	first := len(e.msgs)
+	for i := range e.changes {
+		first = min(first, i)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), "."+filepath.Base(e.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	if err := e.write(tmp, first, info.Size()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), e.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if d, err := os.Open(filepath.Dir(e.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return e.load()
+}
+
+// checkUnchanged verifies that the file still holds the mailbox as it was
+// loaded, possibly with messages appended.
+func (e *Editor) checkUnchanged(info os.FileInfo) error {
+	cur, err := e.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(cur, info) || info.Size() < e.size {
+		return ErrMailboxChanged
+	}
+
+	if info.Size() > e.size && len(e.msgs) > 0 {
+		// The last message must still start where it did.
+		b := make([]byte, 5)
+		if _, err := e.f.ReadAt(b, e.msgs[len(e.msgs)-1].start); err != nil || string(b) != "From " {
+			return ErrMailboxChanged
+		}
+	}
+
+	return nil
+}
+
+// write writes the new mailbox to tmp: the data before message first as is,
+// then the messages from first on with the changes applied, then whatever was
+// appended to the file after it was loaded, up to size.
+func (e *Editor) write(tmp *os.File, first int, size int64) error {
+	if err := tmp.Chmod(e.mode()); err != nil {
+		return err
+	}
+
+	start := e.size
+	if first < len(e.msgs) {
+		start = e.msgs[first].start
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(e.f, 0, start)); err != nil {
+		return err
+	}
+
+	for i := first; i < len(e.msgs); i++ {
+		m := e.msgs[i]
+
+		c, ok := e.changes[i]
+		switch {
+		case !ok:
+			_, err := io.Copy(tmp, io.NewSectionReader(e.f, m.start, m.end-m.start))
+			if err != nil {
+				return err
+			}
+		case c.deleted:
+		default:
+			if _, err := io.WriteString(tmp, m.sep+"\n"); err != nil {
+				return err
+			}
+			if _, err := tmp.Write(formatEntry(c.data, e.v)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := io.Copy(tmp, io.NewSectionReader(e.f, e.size, size-e.size)); err != nil {
+		return err
+	}
+
+	return tmp.Sync()
+}
+
+func (e *Editor) mode() os.FileMode {
+	if info, err := e.f.Stat(); err == nil {
+		return info.Mode().Perm()
+	}
+
+	return 0o600
+}
+
+// Close unlocks and closes the mailbox file, discarding uncommitted changes.
+func (e *Editor) Close() error {
+	err := e.unlock()
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,215 @@
+package mbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mboxEdit = "From a@example.com Thu Jan  1 00:00:01 2015\r\n" +
+	"From: a@example.com\r\n" +
+	"Subject: One\r\n" +
+	"\r\n" +
+	"CRLF lines are kept as they are.\r\n" +
+	"\r\n" +
+	"From b@example.com Thu Jan  1 00:00:02 2015\n" +
+	"From: b@example.com\n" +
+	"Subject: Two\n" +
+	"\n" +
+	">From the middle.\n" +
+	"\n" +
+	"From c@example.com Thu Jan  1 00:00:03 2015\n" +
+	"From: c@example.com\n" +
+	"Subject: Three\n" +
+	"\n" +
+	"Spam.\n" +
+	"\n" +
+	"From d@example.com Thu Jan  1 00:00:04 2015\n" +
+	"From: d@example.com\n" +
+	"Subject: Four\n" +
+	"\n" +
+	"No trailing blank line.\n"
+
+func writeEditTestFile(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	if err := os.WriteFile(path, []byte(data), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestEditor(t *testing.T) {
+	path := writeEditTestFile(t, mboxEdit)
+
+	e, err := OpenEditor(path, Mboxrd)
+	if err != nil {
+		t.Fatalf("OpenEditor() = %v", err)
+	}
+	defer e.Close()
+
+	if e.Len() != 4 {
+		t.Fatalf("Len() = %d", e.Len())
+	}
+
+	msg, err := e.Message(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "From: b@example.com\nSubject: Two\n\nFrom the middle.\n"; string(msg) != want {
+		t.Errorf("Message(1) = %q, want %q", msg, want)
+	}
+
+	if err := e.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetFlags(1, FlagSeen|FlagOld); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Delete(4); err == nil {
+		t.Errorf("Expected an error for an out of range message")
+	}
+
+	// A message delivered while editing is kept.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := "\nFrom e@example.com Thu Jan  1 00:00:05 2015\nFrom: e@example.com\nSubject: Five\n\nLate.\n"
+	f.WriteString(delivered)
+	f.Close()
+
+	if err := e.Commit(); err != nil {
+		t.Fatalf("Commit() = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := mboxEdit[:strings.Index(mboxEdit, "From b@")]
+	last := mboxEdit[strings.Index(mboxEdit, "From d@"):]
+	want := first +
+		"From b@example.com Thu Jan  1 00:00:02 2015\n" +
+		"From: b@example.com\n" +
+		"Subject: Two\n" +
+		"Status: RO\n" +
+		"\n" +
+		">From the middle.\n" +
+		"\n" +
+		last + delivered
+	if string(b) != want {
+		t.Errorf("Expected:\n%q\ngot\n%q", want, b)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("Unexpected file mode: %v, %v", info.Mode(), err)
+	}
+
+	// The Editor now reflects the new file.
+	if e.Len() != 4 {
+		t.Errorf("Len() = %d after Commit()", e.Len())
+	}
+	if f, err := e.Flags(1); err != nil || f != FlagSeen|FlagOld {
+		t.Errorf("Flags(1) = %s, %v", f, err)
+	}
+	if sep, _ := e.Separator(3); !strings.HasPrefix(sep, "From e@example.com") {
+		t.Errorf("Separator(3) = %q", sep)
+	}
+}
+
+func TestEditorPreservesPrefix(t *testing.T) {
+	path := writeEditTestFile(t, mboxEdit)
+
+	e, err := OpenEditor(path, Mboxrd)
+	if err != nil {
+		t.Fatalf("OpenEditor() = %v", err)
+	}
+	defer e.Close()
+
+	if err := e.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Commit(); err != nil {
+		t.Fatalf("Commit() = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mboxEdit[:strings.Index(mboxEdit, "From d@")]; string(b) != want {
+		t.Errorf("Expected:\n%q\ngot\n%q", want, b)
+	}
+}
+
+func TestEditorChanged(t *testing.T) {
+	path := writeEditTestFile(t, mboxEdit)
+
+	e, err := OpenEditor(path, Mboxrd)
+	if err != nil {
+		t.Fatalf("OpenEditor() = %v", err)
+	}
+	defer e.Close()
+
+	if err := os.WriteFile(path, []byte(mboxWithOneMessage), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Commit(); err != ErrMailboxChanged {
+		t.Errorf("Expected ErrMailboxChanged, got %v", err)
+	}
+}
+
+func TestEditorLocked(t *testing.T) {
+	path := writeEditTestFile(t, mboxEdit)
+	msg := "From: e@example.com\nSubject: Five\n\nLate.\n"
+	opts := &AppendOptions{Lock: &LockOptions{Timeout: 100 * time.Millisecond}}
+
+	e, err := OpenEditor(path, Mboxrd)
+	if err != nil {
+		t.Fatalf("OpenEditor() = %v", err)
+	}
+
+	// Deliveries wait for the Editor, which is locked again after Commit.
+	if _, err := Append(path, "", time.Time{}, strings.NewReader(msg), opts); err != ErrLockTimeout {
+		t.Errorf("Expected ErrLockTimeout, got %v", err)
+	}
+	if err := e.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Commit(); err != nil {
+		t.Fatalf("Commit() = %v", err)
+	}
+	if _, err := Append(path, "", time.Time{}, strings.NewReader(msg), opts); err != ErrLockTimeout {
+		t.Errorf("Expected ErrLockTimeout after Commit(), got %v", err)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if _, err := Append(path, "", time.Time{}, strings.NewReader(msg), opts); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+
+	e, err = OpenEditorOptions(path, Mboxrd, &EditorOptions{NoLock: true})
+	if err != nil {
+		t.Fatalf("OpenEditorOptions() = %v", err)
+	}
+	defer e.Close()
+
+	if _, err := Append(path, "", time.Time{}, strings.NewReader(msg), opts); err != nil {
+		t.Errorf("Append() = %v", err)
+	}
+	if e.Len() != 4 {
+		t.Errorf("Len() = %d", e.Len())
+	}
+}
@@ -0,0 +1,216 @@
+package mbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultExportName is the file name template used by ExportEML when none is
+// given.
+const DefaultExportName = `{{printf "%06d" .Index}}-{{.Subject}}.eml`
+
+var reUnsafeName = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// ExportName holds the values available to an export file name template.
+type ExportName struct {
+	// Index is the zero-based position of the message in the mbox.
+	Index int
+	// Offset is the byte offset of the message in the mbox.
+	Offset int64
+	// Date is the Date header of the message, or its separator date.
+	Date time.Time
+	// Subject is the decoded subject, reduced to characters that are safe
+	// in file names.
+	Subject string
+	// MessageIDHash is a hex SHA-256 prefix of the Message-ID, or of the
+	// message content if it has none.
+	MessageIDHash string
+}
+
+// ExportOptions configures ExportEML.
+type ExportOptions struct {
+	// Name is a text/template producing the file name of each message from
+	// an ExportName, relative to the export directory. It defaults to
+	// DefaultExportName. Names which are already taken get a numeric suffix.
+	Name string
+
+	// Variant is the variant of the source mbox, which decides how quoted
+	// "From " lines are unescaped.
+	Variant Variant
+
+	// CRLF selects CRLF line endings; by default files use LF.
+	CRLF bool
+
+	// Manifest, if non-nil, receives a CSV file listing every exported file
+	// with the index, offset, Message-ID, date and subject of its message,
+	// and a description of the problem if its header could not be parsed.
+	Manifest io.Writer
+}
+
+// ExportEML writes every message read from r to its own .eml file in dir,
+// creating dir if needed. It returns the number of exported messages.
+// Messages whose header cannot be parsed are exported as they are, named with
+// their separator date and an empty subject.
+func ExportEML(r *Reader, dir string, opts *ExportOptions) (int, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = DefaultExportName
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	var manifest *csv.Writer
+	if opts.Manifest != nil {
+		manifest = csv.NewWriter(opts.Manifest)
+		manifest.Write([]string{"file", "index", "offset", "message_id", "date", "subject", "problem"})
+	}
+
+	n := 0
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return n, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		var problem string
+		h, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+		if err != nil {
+			h, problem = &mail.Message{Header: mail.Header{}}, "invalid header: "+err.Error()
+		}
+
+		data := ExportName{Index: r.Index(), Offset: r.Offset()}
+		if data.Date, err = h.Header.Date(); err != nil {
+			_, data.Date, _ = ParseSeparator(r.Separator())
+		}
+
+		subject := h.Header.Get("Subject")
+		if d, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = d
+		}
+		data.Subject = sanitizeName(subject)
+
+		id := strings.TrimSpace(h.Header.Get("Message-Id"))
+		if id != "" {
+			data.MessageIDHash = hashPrefix([]byte(id))
+		} else {
+			data.MessageIDHash = hashPrefix(b)
+		}
+
+		var fn bytes.Buffer
+		if err := tmpl.Execute(&fn, data); err != nil {
+			return n, err
+		}
+
+		out := joinMessage(header, opts.Variant.unescape(body))
+		if opts.CRLF {
+			out = bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n"))
+		}
+
+		path, err := createExportFile(dir, fn.String(), out)
+		if err != nil {
+			return n, err
+		}
+		n++
+
+		if manifest != nil {
+			rel, _ := filepath.Rel(dir, path)
+			date := ""
+			if !data.Date.IsZero() {
+				date = data.Date.Format(time.RFC3339)
+			}
+			manifest.Write([]string{filepath.ToSlash(rel), strconv.Itoa(data.Index), strconv.FormatInt(data.Offset, 10), id, date, subject, problem})
+		}
+	}
+
+	if manifest != nil {
+		manifest.Flush()
+		return n, manifest.Error()
+	}
+
+	return n, nil
+}
+
+// createExportFile writes data to a new file named name below dir, adding a
+// numeric suffix to the name if it is taken. It returns the path of the file.
+func createExportFile(dir, name string, data []byte) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("export file name %q is outside the export directory", name)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			path = base + "-" + strconv.Itoa(i) + ext
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		return path, err
+	}
+}
+
+// sanitizeName reduces s to a short string that is safe to use in file names.
+func sanitizeName(s string) string {
+	s = strings.Trim(reUnsafeName.ReplaceAllString(s, "_"), "_.")
+	if r := []rune(s); len(r) > 60 {
+		s = strings.TrimRight(string(r[:60]), "_.")
+	}
+
+	if s == "" {
+		return "no-subject"
+	}
+
+	return s
+}
+
+func hashPrefix(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:8])
+}
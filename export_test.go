@@ -0,0 +1,105 @@
+package mbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExportEML(t *testing.T) {
+	dir := t.TempDir()
+
+	var manifest bytes.Buffer
+	n, err := ExportEML(NewReader(strings.NewReader(mboxWithThreeMessages)), dir, &ExportOptions{
+		Name:     `{{.Date.Format "2006"}}/{{printf "%03d" .Index}}-{{.Subject}}.eml`,
+		Variant:  Mboxo,
+		CRLF:     true,
+		Manifest: &manifest,
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("ExportEML() = %d, %v", n, err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "2015", "000-Test.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.ReplaceAll(crlfToLf(mboxFirstMessage), "\n>From Herp", "\nFrom Herp")
+	want = strings.ReplaceAll(want, "\n", "\r\n")
+	if string(b) != want {
+		t.Errorf("Expected:\n%q\ngot\n%q", want, string(b))
+	}
+
+	lines := strings.Split(strings.TrimSpace(manifest.String()), "\n")
+	if len(lines) != 4 || lines[0] != "file,index,offset,message_id,date,subject,problem" {
+		t.Fatalf("Unexpected manifest:\n%s", manifest.String())
+	}
+	if !strings.HasPrefix(lines[2], "2015/001-Another_test.eml,1,"+strconv.Itoa(strings.Index(mboxWithThreeMessages, "From derp.herp"))+",,2015-01-02T00:00:01+01:00,Another test,") {
+		t.Errorf("Unexpected manifest line %q", lines[2])
+	}
+}
+
+func TestExportEMLMalformedHeader(t *testing.T) {
+	dir := t.TempDir()
+	data := "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: Broken\nnot a header\n\n>From here.\n\n" + mboxWithThreeMessages
+
+	var manifest bytes.Buffer
+	n, err := ExportEML(NewReader(strings.NewReader(data)), dir, &ExportOptions{Manifest: &manifest})
+	if err != nil || n != 4 {
+		t.Fatalf("ExportEML() = %d, %v", n, err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "000000-no-subject.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "From: a@example.com\nSubject: Broken\nnot a header\n\nFrom here.\n"; string(b) != want {
+		t.Errorf("Expected:\n%q\ngot\n%q", want, string(b))
+	}
+
+	lines := strings.Split(manifest.String(), "\n")
+	if want := `000000-no-subject.eml,0,0,,2015-01-01T00:00:01Z,,"invalid header: `; !strings.HasPrefix(lines[1], want) {
+		t.Errorf("Unexpected manifest line %q", lines[1])
+	}
+}
+
+func TestExportEMLNameCollision(t *testing.T) {
+	dir := t.TempDir()
+
+	n, err := ExportEML(NewReader(strings.NewReader(mboxWithThreeMessages)), dir, &ExportOptions{Name: "mail.eml"})
+	if err != nil || n != 3 {
+		t.Fatalf("ExportEML() = %d, %v", n, err)
+	}
+
+	for _, name := range []string{"mail.eml", "mail-1.eml", "mail-2.eml"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, []byte("\r\n")) {
+			t.Errorf("%s - Expected LF line endings", name)
+		}
+	}
+
+	if _, err := ExportEML(NewReader(strings.NewReader(mboxWithOneMessage)), dir, &ExportOptions{Name: "../escape.eml"}); err == nil {
+		t.Errorf("Expected an error for a file name outside the export directory")
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"Re: Hello / world?": "Re_Hello_world",
+		"...":                "no-subject",
+		"Über café":          "Über_café",
+	}
+
+	for in, want := range tests {
+		if got := sanitizeName(in); got != want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package mbox
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Flags is the state of a message, as recorded by the mbox Status and
+// X-Status headers (mutt, Pine, mailx), Maildir file names, MH sequences,
+// Babyl attributes and Thunderbird X-Mozilla-Status headers.
+type Flags uint16
+
+const (
+	// FlagSeen marks a message as read: Status "R", Maildir "S".
+	FlagSeen Flags = 1 << iota
+	// FlagOld marks a message seen by a mail client, read or not: Status
+	// "O", a Maildir message in cur/.
+	FlagOld
+	// FlagAnswered marks a replied message: X-Status "A", Maildir "R".
+	FlagAnswered
+	// FlagFlagged marks a flagged message: X-Status "F", Maildir "F".
+	FlagFlagged
+	// FlagDeleted marks a message for deletion: X-Status "D", Maildir "T".
+	FlagDeleted
+	// FlagDraft marks a draft: X-Status "T", Maildir "D".
+	FlagDraft
+	// FlagForwarded marks a forwarded message: Maildir "P". It has no
+	// Status or X-Status letter.
+	FlagForwarded
+)
+
+// flagLetters maps flags to their Status or X-Status letter and Maildir info
+// letter, in the order the headers are written.
+var flagLetters = []struct {
+	flag            Flags
+	status, maildir byte
+}{
+	{FlagSeen, 'R', 'S'},
+	{FlagOld, 'O', 0},
+	{FlagAnswered, 'A', 'R'},
+	{FlagFlagged, 'F', 'F'},
+	{FlagDeleted, 'D', 'T'},
+	{FlagDraft, 'T', 'D'},
+	{FlagForwarded, 0, 'P'},
+}
+
+// ParseStatus returns the flags recorded in the values of the Status and
+// X-Status headers. Letters are accepted in either header.
+func ParseStatus(status, xstatus string) Flags {
+	var f Flags
+	for _, c := range status + xstatus {
+		for _, l := range flagLetters {
+			if l.status != 0 && c == rune(l.status) {
+				f |= l.flag
+			}
+		}
+	}
+
+	return f
+}
+
+// Status returns the values of the Status and X-Status headers recording f.
+// Either may be empty.
+func (f Flags) Status() (string, string) {
+	var status, xstatus []byte
+	for _, l := range flagLetters {
+		if l.status == 0 || !f.Has(l.flag) {
+			continue
+		}
+
+		if l.flag == FlagSeen || l.flag == FlagOld {
+			status = append(status, l.status)
+		} else {
+			xstatus = append(xstatus, l.status)
+		}
+	}
+
+	return string(status), string(xstatus)
+}
+
+// ParseMaildirFlags returns the flags recorded in the info of a Maildir file
+// name, such as "FS". cur reports whether the file is in cur/ rather than
+// new/.
+func ParseMaildirFlags(info string, cur bool) Flags {
+	var f Flags
+	if cur {
+		f |= FlagOld
+	}
+
+	for _, c := range info {
+		for _, l := range flagLetters {
+			if l.maildir != 0 && c == rune(l.maildir) {
+				f |= l.flag
+			}
+		}
+	}
+
+	return f
+}
+
+// Maildir returns the Maildir info flags recording f, in ASCII order.
+func (f Flags) Maildir() string {
+	var b []byte
+	for _, l := range flagLetters {
+		if l.maildir != 0 && f.Has(l.flag) {
+			b = append(b, l.maildir)
+		}
+	}
+
+	return sortFlags(string(b))
+}
+
+// FlagsFromMozilla returns the flags recorded in Thunderbird flags. Messages
+// without the MozillaNew flag are old.
+func FlagsFromMozilla(m MozillaFlags) Flags {
+	var f Flags
+	if m.Has(MozillaRead) {
+		f |= FlagSeen
+	}
+	if !m.Has(MozillaNew) {
+		f |= FlagOld
+	}
+	if m.Has(MozillaReplied) {
+		f |= FlagAnswered
+	}
+	if m.Has(MozillaMarked) {
+		f |= FlagFlagged
+	}
+	if m.Deleted() {
+		f |= FlagDeleted
+	}
+	if m.Has(MozillaForwarded) {
+		f |= FlagForwarded
+	}
+
+	return f
+}
+
+// Mozilla returns the Thunderbird flags m updated to record f. Flags without
+// a counterpart in f, such as MozillaAttachment, are kept.
+func (f Flags) Mozilla(m MozillaFlags) MozillaFlags {
+	set := func(flag MozillaFlags, on bool) {
+		if on {
+			m |= flag
+		} else {
+			m &^= flag
+		}
+	}
+
+	set(MozillaRead, f.Has(FlagSeen))
+	set(MozillaNew, !f.Has(FlagOld))
+	set(MozillaReplied, f.Has(FlagAnswered))
+	set(MozillaMarked, f.Has(FlagFlagged))
+	set(MozillaForwarded, f.Has(FlagForwarded))
+	if !f.Has(FlagDeleted) {
+		m &^= MozillaExpunged | MozillaIMAPDeleted
+	} else if !m.Deleted() {
+		m |= MozillaExpunged
+	}
+
+	return m
+}
+
+// mhSequences returns the MH sequences recording f: "unseen", "flagged" and
+// "replied".
+func (f Flags) mhSequences() []string {
+	var seqs []string
+	if !f.Has(FlagSeen) {
+		seqs = append(seqs, "unseen")
+	}
+	if f.Has(FlagFlagged) {
+		seqs = append(seqs, "flagged")
+	}
+	if f.Has(FlagAnswered) {
+		seqs = append(seqs, "replied")
+	}
+
+	return seqs
+}
+
+// flagsFromMHSequences is the reverse of mhSequences. Messages outside the
+// unseen sequence are read, and all messages are old.
+func flagsFromMHSequences(seqs []string) Flags {
+	f := FlagSeen | FlagOld
+	for _, s := range seqs {
+		switch s {
+		case "unseen":
+			f &^= FlagSeen
+		case "flagged":
+			f |= FlagFlagged
+		case "replied":
+			f |= FlagAnswered
+		}
+	}
+
+	return f
+}
+
+// Flags returns the flags recorded in the Babyl attributes of the message.
+// Messages are old, and read unless they have the "unseen" attribute.
+func (m *BabylMessage) Flags() Flags {
+	f := FlagSeen | FlagOld
+	for _, a := range m.Attributes {
+		switch a {
+		case "unseen":
+			f &^= FlagSeen
+		case "answered":
+			f |= FlagAnswered
+		case "deleted":
+			f |= FlagDeleted
+		case "forwarded", "resent":
+			f |= FlagForwarded
+		}
+	}
+
+	return f
+}
+
+// Has reports whether all of flags are set.
+func (f Flags) Has(flags Flags) bool {
+	return f&flags == flags
+}
+
+// String returns the names of the flags set in f, separated by commas.
+func (f Flags) String() string {
+	var names []string
+	for _, n := range []struct {
+		flag Flags
+		name string
+	}{
+		{FlagSeen, "seen"},
+		{FlagOld, "old"},
+		{FlagAnswered, "answered"},
+		{FlagFlagged, "flagged"},
+		{FlagDeleted, "deleted"},
+		{FlagDraft, "draft"},
+		{FlagForwarded, "forwarded"},
+	} {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// HeaderFlags returns the flags recorded in a message header by its Status
+// and X-Status fields, and its X-Mozilla-Status fields if it has no Status
+// field.
+func HeaderFlags(h mail.Header) Flags {
+	if h.Get("Status") == "" && h.Get("X-Status") == "" && h.Get("X-Mozilla-Status") != "" {
+		if m, err := ParseMozillaStatus(h.Get("X-Mozilla-Status"), h.Get("X-Mozilla-Status2")); err == nil {
+			return FlagsFromMozilla(m)
+		}
+	}
+
+	return ParseStatus(h.Get("Status"), h.Get("X-Status"))
+}
+
+// SetFlags returns msg, a message with its header and body, with its Status
+// and X-Status fields replaced to record f. X-Mozilla-Status fields, when
+// present, are updated too, so that mutt and Thunderbird agree. Fields keep
+// their position in the header; the message is returned with LF line endings.
+func SetFlags(msg []byte, f Flags) []byte {
+	header, body := splitMessage(normalizeNewlines(msg))
+
+	status, xstatus := f.Status()
+	header = headerSet(header, "Status", status)
+	header = headerSet(header, "X-Status", xstatus)
+
+	if h, err := mail.ReadMessage(strings.NewReader(string(header) + "\n")); err == nil && h.Header.Get("X-Mozilla-Status") != "" {
+		m, _ := ParseMozillaStatus(h.Header.Get("X-Mozilla-Status"), h.Header.Get("X-Mozilla-Status2"))
+		ms, ms2 := f.Mozilla(m).Status()
+		header = headerSet(header, "X-Mozilla-Status", ms)
+		if h.Header.Get("X-Mozilla-Status2") != "" || ms2 != "00000000" {
+			header = headerSet(header, "X-Mozilla-Status2", ms2)
+		}
+	}
+
+	return joinMessage(header, body)
+}
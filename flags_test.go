@@ -0,0 +1,124 @@
+package mbox
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestFlagsConversions(t *testing.T) {
+	tests := []struct {
+		status, xstatus string
+		flags           Flags
+		maildir         string
+	}{
+		{"", "", 0, ""},
+		{"O", "", FlagOld, ""},
+		{"RO", "", FlagSeen | FlagOld, "S"},
+		{"RO", "AF", FlagSeen | FlagOld | FlagAnswered | FlagFlagged, "FRS"},
+		{"R", "DT", FlagSeen | FlagDeleted | FlagDraft, "DST"},
+	}
+
+	for _, tt := range tests {
+		f := ParseStatus(tt.status, tt.xstatus)
+		if f != tt.flags {
+			t.Errorf("ParseStatus(%q, %q) = %s, want %s", tt.status, tt.xstatus, f, tt.flags)
+		}
+		if s, xs := f.Status(); s != tt.status || xs != tt.xstatus {
+			t.Errorf("%s.Status() = %q, %q", f, s, xs)
+		}
+		if m := f.Maildir(); m != tt.maildir {
+			t.Errorf("%s.Maildir() = %q, want %q", f, m, tt.maildir)
+		}
+		if got := ParseMaildirFlags(tt.maildir, f.Has(FlagOld)); got != f {
+			t.Errorf("ParseMaildirFlags(%q) = %s, want %s", tt.maildir, got, f)
+		}
+	}
+
+	if f := ParseMaildirFlags("PS", true); f != FlagSeen|FlagOld|FlagForwarded || f.String() != "seen,old,forwarded" {
+		t.Errorf("ParseMaildirFlags() = %s", f)
+	}
+
+	m := MozillaRead | MozillaReplied | MozillaAttachment
+	if f := FlagsFromMozilla(m); f != FlagSeen|FlagOld|FlagAnswered {
+		t.Errorf("FlagsFromMozilla() = %s", f)
+	}
+	if got := (FlagOld | FlagFlagged | FlagDeleted).Mozilla(m); got != MozillaMarked|MozillaExpunged|MozillaAttachment {
+		t.Errorf("Mozilla() = %s", got)
+	}
+	if got := FlagSeen.Mozilla(0); got != MozillaRead|MozillaNew {
+		t.Errorf("Mozilla() = %s", got)
+	}
+
+	b := &BabylMessage{Attributes: []string{"unseen", "answered"}}
+	if f := b.Flags(); f != FlagOld|FlagAnswered {
+		t.Errorf("BabylMessage.Flags() = %s", f)
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Flags
+	}{
+		{"Status: RO\nX-Status: F\n", FlagSeen | FlagOld | FlagFlagged},
+		{"X-Mozilla-Status: 0009\nX-Mozilla-Status2: 00000000\n", FlagSeen | FlagOld | FlagDeleted},
+		// Status takes precedence over X-Mozilla-Status.
+		{"Status: O\nX-Mozilla-Status: 0001\n", FlagOld},
+		{"Subject: none\n", 0},
+	}
+
+	for _, tt := range tests {
+		m, err := mail.ReadMessage(strings.NewReader(tt.header + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := HeaderFlags(m.Header); got != tt.want {
+			t.Errorf("HeaderFlags(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	tests := []struct {
+		msg   string
+		flags Flags
+		want  string
+	}{
+		{
+			"From: a@example.com\r\nSubject: New\r\n\r\nBody.\r\n",
+			FlagSeen | FlagOld,
+			"From: a@example.com\nSubject: New\nStatus: RO\n\nBody.\n",
+		},
+		{
+			"From: a@example.com\nStatus: O\nX-Status: A\nSubject: Read\n\nBody.\n",
+			FlagSeen | FlagOld | FlagDeleted,
+			"From: a@example.com\nStatus: RO\nX-Status: D\nSubject: Read\n\nBody.\n",
+		},
+		{
+			"X-Mozilla-Status: 0001\nX-Mozilla-Status2: 10000000\nFrom: a@example.com\nStatus: RO\n\nBody.\n",
+			FlagSeen | FlagOld | FlagDeleted,
+			"X-Mozilla-Status: 0009\nX-Mozilla-Status2: 10000000\nFrom: a@example.com\nStatus: RO\nX-Status: D\n\nBody.\n",
+		},
+		{
+			"X-Mozilla-Status: 0001\nFrom: a@example.com\n\nBody.\n",
+			0,
+			"X-Mozilla-Status: 0000\nFrom: a@example.com\nX-Mozilla-Status2: 00010000\n\nBody.\n",
+		},
+	}
+
+	for i, tt := range tests {
+		got := string(SetFlags([]byte(tt.msg), tt.flags))
+		if got != tt.want {
+			t.Errorf("%d - Expected:\n%s\ngot\n%s", i, tt.want, got)
+		}
+
+		m, err := mail.ReadMessage(strings.NewReader(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := HeaderFlags(m.Header); f != tt.flags {
+			t.Errorf("%d - HeaderFlags() = %s, want %s", i, f, tt.flags)
+		}
+	}
+}
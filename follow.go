@@ -0,0 +1,290 @@
+package mbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultPollInterval is how often a Follower checks its file for new
+	// data by default.
+	DefaultPollInterval = time.Second
+	// DefaultQuietPeriod is how long the last message of a file must stay
+	// unchanged before a Follower returns it, by default.
+	DefaultQuietPeriod = 5 * time.Second
+
+	// followChunk is the amount of data a Follower reads at once.
+	followChunk = 1 << 20
+)
+
+var (
+	ErrFileTruncated = errors.New("mailbox file was truncated")
+	ErrFileRotated   = errors.New("mailbox file was replaced")
+)
+
+// FollowOptions configures Follow.
+type FollowOptions struct {
+	// PollInterval is how often the file is checked for new data. It
+	// defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// QuietPeriod is how long the file must stop growing before its last
+	// message, which has no separator after it, is considered complete. It
+	// defaults to DefaultQuietPeriod.
+	QuietPeriod time.Duration
+
+	// FromEnd skips the messages already in the file, like tail -f.
+	FromEnd bool
+}
+
+// Follower reads messages from an mbox file which is being appended to, such
+// as a spool an MTA delivers to. It polls the file for new data, and returns
+// a message once the separator of the next one is written, or once the file
+// has stopped growing for the quiet period.
+//
+// If the file is truncated, or replaced by a new file at the same path, the
+// next call to NextMessage returns ErrFileTruncated or ErrFileRotated, after
+// the messages complete until then, and the following calls read the new
+// content from its start.
+type Follower struct {
+	path    string
+	opts    FollowOptions
+	f       *os.File
+	base    int64
+	pending []byte
+	grown   time.Time
+	queue   []followedMessage
+	reset   error
+	cur     followedMessage
+}
+
+type followedMessage struct {
+	data   []byte
+	offset int64
+	sep    string
+}
+
+// Follow returns a Follower for the mbox file at path. A nil opts uses the
+// defaults.
+func Follow(path string, opts *FollowOptions) (*Follower, error) {
+	fl := &Follower{path: path, grown: time.Now()}
+	if opts != nil {
+		fl.opts = *opts
+	}
+	if fl.opts.PollInterval <= 0 {
+		fl.opts.PollInterval = DefaultPollInterval
+	}
+	if fl.opts.QuietPeriod <= 0 {
+		fl.opts.QuietPeriod = DefaultQuietPeriod
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fl.f = f
+
+	if fl.opts.FromEnd {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		fl.base = info.Size()
+	}
+
+	return fl, nil
+}
+
+// Offset returns the byte offset of the "From " separator line of the message
+// most recently returned by NextMessage.
+func (fl *Follower) Offset() int64 {
+	return fl.cur.offset
+}
+
+// Separator returns the "From " separator line of the message most recently
+// returned by NextMessage, without its line ending.
+func (fl *Follower) Separator() string {
+	return fl.cur.sep
+}
+
+// NextMessage is like NextMessageContext with a context which is never
+// canceled: it waits until a message is available.
+func (fl *Follower) NextMessage() (io.Reader, error) {
+	return fl.NextMessageContext(context.Background())
+}
+
+// NextMessageContext returns the next message text (containing both the header
+// and the body), waiting for it as long as ctx allows. Messages use CRLF line
+// endings, like those of Reader.
+func (fl *Follower) NextMessageContext(ctx context.Context) (io.Reader, error) {
+	for {
+		if len(fl.queue) > 0 {
+			fl.cur, fl.queue = fl.queue[0], fl.queue[1:]
+			return bytes.NewReader(fl.cur.data), nil
+		}
+
+		if fl.reset != nil {
+			err := fl.reset
+			fl.reset = nil
+			return nil, err
+		}
+
+		if err := fl.poll(); err != nil {
+			return nil, err
+		}
+		if len(fl.queue) > 0 || fl.reset != nil {
+			continue
+		}
+
+		t := time.NewTimer(fl.opts.PollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// poll reads the data appended to the file and queues the messages it
+// completes, then checks whether the file was truncated or replaced.
+func (fl *Follower) poll() error {
+	grew, err := fl.read()
+	if err != nil {
+		return err
+	}
+
+	info, err := fl.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() < fl.base+int64(len(fl.pending)) {
+		fl.reset = ErrFileTruncated
+		fl.base, fl.pending, fl.grown = 0, nil, time.Now()
+		return nil
+	}
+
+	if pinfo, err := os.Stat(fl.path); err == nil && !os.SameFile(info, pinfo) {
+		// All of the old file has been read: its last message is complete.
+		fl.parse(true)
+
+		f, err := os.Open(fl.path)
+		if err != nil {
+			return err
+		}
+		fl.f.Close()
+		fl.f = f
+
+		fl.reset = ErrFileRotated
+		fl.base, fl.pending, fl.grown = 0, nil, time.Now()
+		return nil
+	}
+
+	if !grew && len(fl.pending) > 0 && time.Since(fl.grown) >= fl.opts.QuietPeriod {
+		fl.parse(true)
+	}
+
+	return nil
+}
+
+// read reads the data appended to the file since the last call, queueing
+// complete messages as it goes. It reports whether there was new data.
+func (fl *Follower) read() (bool, error) {
+	grew := false
+	buf := make([]byte, followChunk)
+	for {
+		n, err := fl.f.ReadAt(buf, fl.base+int64(len(fl.pending)))
+		if n > 0 {
+			fl.pending = append(fl.pending, buf[:n]...)
+			fl.grown = time.Now()
+			grew = true
+			fl.parse(false)
+		}
+
+		if err == io.EOF || (err == nil && n < len(buf)) {
+			return grew, nil
+		} else if err != nil {
+			return grew, err
+		}
+	}
+}
+
+// parse queues the messages of the pending data which are followed by a
+// separator, and all of them if final is set, and drops their data.
+func (fl *Follower) parse(final bool) {
+	for len(fl.pending) > 0 {
+		r := NewReader(bytes.NewReader(fl.pending))
+
+		var msgs []followedMessage
+		for {
+			msg, err := r.NextMessage()
+			if err != nil {
+				break
+			}
+
+			data, err := io.ReadAll(msg)
+			if err != nil {
+				break
+			}
+
+			msgs = append(msgs, followedMessage{data: data, offset: fl.base + r.Offset(), sep: r.Separator()})
+		}
+
+		if len(msgs) == 0 {
+			if !final && bytes.HasPrefix(fl.pending, []byte("From ")) {
+				// Wait for the header, which tells a separator apart.
+				return
+			}
+
+			// The data does not start with a message, as when the end of
+			// one is written after the quiet period: skip to the next line
+			// which may be a separator.
+			n := len(fl.pending)
+			if fl.skip(final); len(fl.pending) == n {
+				return
+			}
+			continue
+		}
+
+		if final {
+			fl.queue = append(fl.queue, msgs...)
+			fl.base += int64(len(fl.pending))
+			fl.pending = nil
+			return
+		}
+
+		last := msgs[len(msgs)-1]
+		fl.queue = append(fl.queue, msgs[:len(msgs)-1]...)
+		fl.drop(last.offset - fl.base)
+		return
+	}
+}
+
+// skip drops the pending data up to the next line starting with "From ", or
+// all of it if final is set and there is none.
+func (fl *Follower) skip(final bool) {
+	if i := bytes.Index(fl.pending[1:], []byte("\nFrom ")); i >= 0 {
+		fl.drop(int64(i + 2))
+	} else if final {
+		fl.drop(int64(len(fl.pending)))
+	} else if i := bytes.LastIndexByte(fl.pending, '\n'); i >= 0 {
+		// Keep the last line, which may be an incomplete separator.
+		fl.drop(int64(i + 1))
+	}
+}
+
+func (fl *Follower) drop(n int64) {
+	fl.pending = fl.pending[n:]
+	fl.base += n
+}
+
+// Close closes the file. It must not be called while NextMessage runs.
+func (fl *Follower) Close() error {
+	return fl.f.Close()
+}
@@ -0,0 +1,155 @@
+package mbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func followTestMessage(name string) string {
+	return "From " + name + "@example.com Thu Jan  1 00:00:01 2015\n" +
+		"From: " + name + "@example.com\n" +
+		"Subject: " + name + "\n" +
+		"\n" +
+		"Hello from " + name + ".\n" +
+		"\n"
+}
+
+func appendString(t *testing.T, path, s string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextFollowed returns the subject of the next message, or the error.
+func nextFollowed(t *testing.T, fl *Follower, timeout time.Duration) (string, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	msg, err := fl.NextMessageContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := mail.ReadMessage(msg)
+	if err != nil {
+		t.Fatalf("mail.ReadMessage() = %v", err)
+	}
+
+	return m.Header.Get("Subject"), nil
+}
+
+func TestFollower(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	appendString(t, path, followTestMessage("a")+followTestMessage("b"))
+
+	quiet := 300 * time.Millisecond
+	fl, err := Follow(path, &FollowOptions{PollInterval: 10 * time.Millisecond, QuietPeriod: quiet})
+	if err != nil {
+		t.Fatalf("Follow() = %v", err)
+	}
+	defer fl.Close()
+
+	expect := func(want string, timeout time.Duration) {
+		t.Helper()
+		if got, err := nextFollowed(t, fl, timeout); err != nil || got != want {
+			t.Fatalf("NextMessage() = %q, %v, want %q", got, err, want)
+		}
+	}
+	expectErr := func(want error, timeout time.Duration) {
+		t.Helper()
+		if got, err := nextFollowed(t, fl, timeout); !errors.Is(err, want) {
+			t.Fatalf("NextMessage() = %q, %v, want %v", got, err, want)
+		}
+	}
+
+	expect("a", time.Second)
+	if fl.Offset() != 0 || !strings.HasPrefix(fl.Separator(), "From a@example.com") {
+		t.Errorf("Unexpected position %d, %q", fl.Offset(), fl.Separator())
+	}
+
+	// The last message is held back until the next separator is written...
+	expectErr(context.DeadlineExceeded, quiet/3)
+	appendString(t, path, "From c@example.com Thu Jan  1 00:00:01 2015\n")
+	expectErr(context.DeadlineExceeded, quiet/3)
+	appendString(t, path, "From: c@example.com\nSubject: c\n\nHello")
+	expect("b", time.Second)
+	if want := int64(len(followTestMessage("a"))); fl.Offset() != want {
+		t.Errorf("Offset() = %d, want %d", fl.Offset(), want)
+	}
+
+	// ... or until the file has not grown for the quiet period.
+	start := time.Now()
+	expect("c", 5*quiet)
+	if d := time.Since(start); d < quiet/2 {
+		t.Errorf("Message returned after %v, before the quiet period", d)
+	}
+
+	// Data completing a message after the quiet period is skipped.
+	appendString(t, path, " again.\n\n"+followTestMessage("d")+followTestMessage("e"))
+	expect("d", time.Second)
+	expect("e", 5*quiet)
+
+	// Truncation restarts from the start of the file.
+	if err := os.WriteFile(path, []byte(followTestMessage("f")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectErr(ErrFileTruncated, time.Second)
+	expect("f", 5*quiet)
+
+	// Rotation reads the rest of the old file, then the new one.
+	appendString(t, path, followTestMessage("g"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendString(t, path+".1", followTestMessage("h"))
+	appendString(t, path, followTestMessage("i")+followTestMessage("j"))
+
+	expect("g", time.Second)
+	expect("h", time.Second)
+	expectErr(ErrFileRotated, time.Second)
+	expect("i", time.Second)
+	if fl.Offset() != 0 {
+		t.Errorf("Offset() = %d after rotation", fl.Offset())
+	}
+	expect("j", 5*quiet)
+}
+
+func TestFollowerFromEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	appendString(t, path, followTestMessage("a"))
+
+	fl, err := Follow(path, &FollowOptions{PollInterval: 10 * time.Millisecond, QuietPeriod: 100 * time.Millisecond, FromEnd: true})
+	if err != nil {
+		t.Fatalf("Follow() = %v", err)
+	}
+	defer fl.Close()
+
+	appendString(t, path, followTestMessage("b"))
+
+	msg, err := fl.NextMessage()
+	if err != nil {
+		t.Fatalf("NextMessage() = %v", err)
+	}
+	b, _ := io.ReadAll(msg)
+	if !strings.Contains(string(b), "Subject: b\r\n") {
+		t.Errorf("Unexpected message:\n%s", b)
+	}
+}
@@ -0,0 +1,81 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Format identifies a mailbox file format.
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatMbox
+	FormatMMDF
+	FormatBabyl
+)
+
+// detectSize is the number of bytes DetectFormat needs to see.
+const detectSize = 512
+
+// MessageIterator is implemented by the readers of all mailbox formats.
+type MessageIterator interface {
+	// NextMessage returns the next message text (containing both the header
+	// and the body). It returns io.EOF if there are no messages left.
+	NextMessage() (io.Reader, error)
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatMbox:
+		return "mbox"
+	case FormatMMDF:
+		return "mmdf"
+	case FormatBabyl:
+		return "babyl"
+	}
+
+	return "unknown"
+}
+
+// DetectFormat identifies the format of a mailbox from its first bytes.
+// Leading blank lines are ignored.
+func DetectFormat(prefix []byte) Format {
+	b := bytes.TrimLeft(prefix, "\r\n")
+
+	switch {
+	case bytes.HasPrefix(b, mmdfDelimiter):
+		return FormatMMDF
+	case bytes.HasPrefix(b, []byte("BABYL OPTIONS:")):
+		return FormatBabyl
+	case bytes.HasPrefix(b, []byte("From ")):
+		return FormatMbox
+	}
+
+	return FormatUnknown
+}
+
+// NewMailboxReader detects the format of the mailbox data provided by r and
+// returns a reader for it. It returns ErrInvalidFormat if the format is not
+// recognised.
+func NewMailboxReader(r io.Reader) (MessageIterator, Format, error) {
+	br := bufio.NewReaderSize(r, detectSize)
+
+	prefix, err := br.Peek(detectSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, FormatUnknown, err
+	}
+
+	switch f := DetectFormat(prefix); f {
+	case FormatMbox:
+		return NewReader(br), f, nil
+	case FormatMMDF:
+		return NewMMDFReader(br), f, nil
+	case FormatBabyl:
+		return NewBabylReader(br), f, nil
+	}
+
+	return nil, FormatUnknown, ErrInvalidFormat
+}
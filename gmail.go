@@ -0,0 +1,182 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GmailUnlabeled is the label SplitGmailLabels files messages without labels
+// under.
+const GmailUnlabeled = "Unlabeled"
+
+// GmailMessage holds the Gmail metadata of a message from a Google Takeout
+// mbox.
+type GmailMessage struct {
+	// MessageID is the Gmail message ID, in decimal, which Takeout writes as
+	// the sender of the "From " separator line.
+	MessageID string
+	// ThreadID is the Gmail thread ID from the X-GM-THRID header.
+	ThreadID string
+	// Labels are the labels from the X-Gmail-Labels header, such as
+	// "Inbox", "Important" or "Work/Projects".
+	Labels []string
+}
+
+// NewGmailMessage returns the Gmail metadata found in the separator line and
+// header of a message.
+func NewGmailMessage(separator string, h mail.Header) *GmailMessage {
+	m := &GmailMessage{
+		ThreadID: strings.TrimSpace(h.Get("X-GM-THRID")),
+		Labels:   ParseGmailLabels(h.Get("X-Gmail-Labels")),
+	}
+
+	if sender, _, err := ParseSeparator(separator); err == nil {
+		id, _, _ := strings.Cut(sender, "@")
+		if _, err := strconv.ParseUint(id, 10, 64); err == nil {
+			m.MessageID = id
+		}
+	}
+
+	return m
+}
+
+// ParseGmailLabels splits the value of an X-Gmail-Labels header. Labels are
+// separated by commas; labels containing commas are quoted, with backslash
+// escapes inside quotes. Encoded words are decoded.
+func ParseGmailLabels(v string) []string {
+	var labels []string
+	var b strings.Builder
+	quoted, escaped := false, false
+
+	add := func() {
+		label := strings.TrimSpace(b.String())
+		b.Reset()
+		if label == "" {
+			return
+		}
+		if s, err := new(mime.WordDecoder).DecodeHeader(label); err == nil {
+			label = s
+		}
+		labels = append(labels, label)
+	}
+
+	for _, c := range v {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			add()
+		default:
+			b.WriteRune(c)
+		}
+	}
+	add()
+
+	return labels
+}
+
+// GmailReader reads a Google Takeout mbox, parsing the Gmail metadata of each
+// message.
+type GmailReader struct {
+	r   *Reader
+	cur *GmailMessage
+}
+
+// NewGmailReader returns a GmailReader reading messages from r.
+func NewGmailReader(r *Reader) *GmailReader {
+	return &GmailReader{r: r}
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (g *GmailReader) NextMessage() (io.Reader, error) {
+	g.cur = nil
+
+	msg, err := g.r.NextMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	header, rest, err := readHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var h mail.Header
+	if m, err := mail.ReadMessage(bytes.NewReader(append(header, "\r\n"...))); err == nil {
+		h = m.Header
+	}
+	g.cur = NewGmailMessage(g.r.Separator(), h)
+
+	return io.MultiReader(bytes.NewReader(header), rest), nil
+}
+
+// Message returns the Gmail metadata of the message most recently returned by
+// NextMessage.
+func (g *GmailReader) Message() *GmailMessage {
+	return g.cur
+}
+
+// Reader returns the underlying Reader.
+func (g *GmailReader) Reader() *Reader {
+	return g.r
+}
+
+// SplitGmailLabels writes the messages read from r, a Takeout mbox of variant
+// v, to one mbox per label in dir, named after the label with an ".mbox"
+// extension. Existing files are replaced. A message with several labels is
+// written to each of their files; messages without labels go to the
+// GmailUnlabeled file. It returns the number of messages written per label.
+func SplitGmailLabels(r *Reader, dir string, v Variant) (map[string]int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := newFileSplitter(dir)
+	defer s.closeAll()
+
+	counts := map[string]int{}
+	g := NewGmailReader(r)
+	for {
+		msg, err := g.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return counts, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return counts, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		out, err := reformatMessage(r.Separator(), header, body, v)
+		if err != nil {
+			return counts, err
+		}
+
+		labels := g.Message().Labels
+		if len(labels) == 0 {
+			labels = []string{GmailUnlabeled}
+		}
+		for _, label := range labels {
+			if err := s.write(label, sanitizeName(label)+".mbox", out); err != nil {
+				return counts, err
+			}
+			counts[label]++
+		}
+	}
+
+	return counts, s.closeAll()
+}
@@ -0,0 +1,133 @@
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mboxTakeout = `From 1780000000000000001@xxx Mon Jan 05 10:00:00 +0000 2015
+X-GM-THRID: 1780000000000000001
+X-Gmail-Labels: Inbox,Important,"Clients, Europe",Opened
+From: alice@example.com
+Subject: First
+
+>From the archive.
+
+From 1780000000000000002@xxx Tue Jan 06 10:00:00 +0000 2015
+X-GM-THRID: 1780000000000000001
+X-Gmail-Labels: Sent,"Clients, Europe"
+From: bob@example.com
+Subject: Re: First
+
+Reply.
+
+From 1780000000000000003@xxx Wed Jan 07 10:00:00 +0000 2015
+X-GM-THRID: 1780000000000000003
+From: carol@example.com
+Subject: No labels
+
+Nothing.
+`
+
+func TestParseGmailLabels(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"Inbox,Important,Opened", []string{"Inbox", "Important", "Opened"}},
+		{`Inbox,"Clients, Europe",Work/Projects`, []string{"Inbox", "Clients, Europe", "Work/Projects"}},
+		{`"Say \"hi\", then leave", Category Updates`, []string{`Say "hi", then leave`, "Category Updates"}},
+		{"=?UTF-8?Q?Cl=C3=A9s?=,Archived", []string{"Clés", "Archived"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := ParseGmailLabels(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseGmailLabels(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGmailReader(t *testing.T) {
+	g := NewGmailReader(NewReader(strings.NewReader(mboxTakeout)))
+
+	want := []GmailMessage{
+		{"1780000000000000001", "1780000000000000001", []string{"Inbox", "Important", "Clients, Europe", "Opened"}},
+		{"1780000000000000002", "1780000000000000001", []string{"Sent", "Clients, Europe"}},
+		{"1780000000000000003", "1780000000000000003", nil},
+	}
+
+	for i, w := range want {
+		msg, err := g.NextMessage()
+		if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		if !reflect.DeepEqual(*g.Message(), w) {
+			t.Errorf("%d - Message() = %+v, want %+v", i, *g.Message(), w)
+		}
+
+		// The header read for the metadata is still part of the message.
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+		if m.Header.Get("X-GM-THRID") != w.ThreadID {
+			t.Errorf("%d - Unexpected X-GM-THRID %q", i, m.Header.Get("X-GM-THRID"))
+		}
+	}
+
+	if _, err := g.NextMessage(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestSplitGmailLabels(t *testing.T) {
+	dir := t.TempDir()
+
+	// Existing files are replaced.
+	if err := os.WriteFile(filepath.Join(dir, "Inbox.mbox"), []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := SplitGmailLabels(NewReader(strings.NewReader(mboxTakeout)), dir, Mboxrd)
+	if err != nil {
+		t.Fatalf("SplitGmailLabels() = %v", err)
+	}
+
+	wantCounts := map[string]int{"Inbox": 1, "Important": 1, "Clients, Europe": 2, "Opened": 1, "Sent": 1, GmailUnlabeled: 1}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("SplitGmailLabels() = %v, want %v", counts, wantCounts)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "Clients_Europe.mbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "From 1780000000000000001@xxx Mon Jan  5 10:00:00 2015\n" +
+		"X-GM-THRID: 1780000000000000001\n" +
+		"X-Gmail-Labels: Inbox,Important,\"Clients, Europe\",Opened\n" +
+		"From: alice@example.com\n" +
+		"Subject: First\n" +
+		"\n" +
+		">From the archive.\n" +
+		"\n" +
+		"From 1780000000000000002@xxx Tue Jan  6 10:00:00 2015\n"
+	if !strings.HasPrefix(string(b), want) {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, b)
+	}
+
+	b, err = os.ReadFile(filepath.Join(dir, "Inbox.mbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "stale") || strings.Count(string(b), "\nFrom ") != 0 {
+		t.Errorf("Unexpected Inbox.mbox:\n%s", b)
+	}
+}
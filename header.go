@@ -0,0 +1,123 @@
+package mbox
+
+import (
+	"bytes"
+	"strings"
+)
+
+// normalizeNewlines converts CRLF line endings to LF.
+func normalizeNewlines(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+}
+
+// splitMessage splits a message with LF line endings into its header, which
+// keeps the newline ending its last field, and its body. Messages without a
+// blank line are all header.
+func splitMessage(b []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(b, []byte("\n")) {
+		return nil, b[1:]
+	}
+
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		return b[:i+1], b[i+2:]
+	}
+
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		return append(b, '\n'), nil
+	}
+
+	return b, nil
+}
+
+// joinMessage is the reverse of splitMessage.
+func joinMessage(header, body []byte) []byte {
+	out := make([]byte, 0, len(header)+len(body)+1)
+	out = append(out, header...)
+	out = append(out, '\n')
+
+	return append(out, body...)
+}
+
+// headerDel removes the named fields, including their continuation lines,
+// from a header.
+func headerDel(header []byte, names ...string) []byte {
+	var (
+		out  []byte
+		skip bool
+	)
+
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			skip = false
+			if i := bytes.IndexByte(line, ':'); i > 0 {
+				name := strings.TrimSpace(string(line[:i]))
+				for _, n := range names {
+					if strings.EqualFold(name, n) {
+						skip = true
+						break
+					}
+				}
+			}
+		}
+
+		if !skip {
+			out = append(out, line...)
+		}
+	}
+
+	return out
+}
+
+// headerAdd appends a field to a header, unless value is empty.
+func headerAdd(header []byte, name, value string) []byte {
+	if value == "" {
+		return header
+	}
+
+	return append(header, name+": "+value+"\n"...)
+}
+
+// headerSet replaces the value of the first occurrence of a field and removes
+// the others, keeping its position in the header. The field is appended if
+// missing, and removed if value is empty.
+func headerSet(header []byte, name, value string) []byte {
+	if value == "" {
+		return headerDel(header, name)
+	}
+
+	var (
+		out        []byte
+		skip, done bool
+	)
+
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			skip = false
+			if i := bytes.IndexByte(line, ':'); i > 0 && strings.EqualFold(strings.TrimSpace(string(line[:i])), name) {
+				skip = true
+				if !done {
+					out = append(out, name+": "+value+"\n"...)
+					done = true
+				}
+			}
+		}
+
+		if !skip {
+			out = append(out, line...)
+		}
+	}
+
+	if !done {
+		out = headerAdd(out, name, value)
+	}
+
+	return out
+}
@@ -0,0 +1,185 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportOrder selects the order in which ImportEML appends messages.
+type ImportOrder int
+
+const (
+	// ImportByName orders messages by file path.
+	ImportByName ImportOrder = iota
+	// ImportByDate orders messages by their Date header, falling back to
+	// the file modification time. Messages with equal dates keep path order.
+	ImportByDate
+)
+
+// ImportOptions configures ImportEML.
+type ImportOptions struct {
+	// Extensions lists the file name extensions of the files to import,
+	// matched case-insensitively. It defaults to ".eml".
+	Extensions []string
+
+	// Order is the order in which messages are appended.
+	Order ImportOrder
+}
+
+type importFile struct {
+	path string
+	date time.Time
+}
+
+// ImportEML appends the RFC 5322 message files found in fsys, walking it
+// recursively, to w. Use os.DirFS to import from a directory. The separator
+// line of each message is made from its Return-Path or From address and its
+// date; body lines are escaped by w. A leading "From " envelope line, as
+// some mail clients save, is dropped. Files whose header cannot be parsed
+// are imported as they are, dated by their modification time. It returns the
+// number of imported messages.
+func ImportEML(fsys fs.FS, w *Writer, opts *ImportOptions) (int, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	exts := opts.Extensions
+	if len(exts) == 0 {
+		exts = []string{".eml"}
+	}
+
+	var files []importFile
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !hasExtension(p, exts) {
+			return nil
+		}
+
+		f := importFile{path: p}
+		if opts.Order == ImportByDate {
+			if f.date, err = importDate(fsys, p, d); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+		}
+		files = append(files, f)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.Order == ImportByDate {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].date.Before(files[j].date)
+		})
+	}
+
+	for n, f := range files {
+		if err := importFileTo(fsys, f.path, w); err != nil {
+			return n, fmt.Errorf("%s: %w", f.path, err)
+		}
+	}
+
+	return len(files), nil
+}
+
+func hasExtension(p string, exts []string) bool {
+	ext := path.Ext(p)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// importDate returns the date of a message file without reading its body.
+func importDate(fsys fs.FS, p string, d fs.DirEntry) (time.Time, error) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if b, _ := br.Peek(5); string(b) == "From " {
+		br.ReadString('\n')
+	}
+
+	if m, err := mail.ReadMessage(br); err == nil {
+		if t, err := m.Header.Date(); err == nil {
+			return t, nil
+		}
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func importFileTo(fsys fs.FS, p string, w *Writer) error {
+	b, err := fs.ReadFile(fsys, p)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(b, []byte("From ")) {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[i+1:]
+		} else {
+			b = nil
+		}
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		// The header is malformed: import the message as it is.
+		m = &mail.Message{Header: mail.Header{}}
+	}
+
+	date, err := m.Header.Date()
+	if err != nil {
+		info, err := fs.Stat(fsys, p)
+		if err != nil {
+			return err
+		}
+		date = info.ModTime()
+	}
+
+	return w.WriteMessage(envelopeSender(m.Header), date, bytes.NewReader(b))
+}
+
+// envelopeSender returns the address for the separator line of a message:
+// its Return-Path, or else its From address.
+func envelopeSender(h mail.Header) string {
+	for _, name := range []string{"Return-Path", "From"} {
+		v := strings.TrimSpace(h.Get(name))
+		if v == "" || v == "<>" {
+			continue
+		}
+
+		if addr, err := mail.ParseAddress(v); err == nil && addr.Address != "" {
+			return addr.Address
+		}
+
+		if v = strings.Trim(v, "<>"); v != "" && !strings.ContainsAny(v, " \t") {
+			return v
+		}
+	}
+
+	return ""
+}
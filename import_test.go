@@ -0,0 +1,90 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestImportEML(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/first.eml":  {Data: []byte("Return-Path: <bounce@example.com>\r\nFrom: Alice <alice@example.com>\r\nDate: Sat, 03 Jan 2015 00:00:00 +0000\r\nSubject: First\r\n\r\nFrom the start.\r\n")},
+		"b/second.EML": {Data: []byte("From: Bob <bob@example.com>\nDate: Thu, 01 Jan 2015 00:00:00 +0000\nSubject: Second\n\nHi.\n")},
+		"c/third.eml":  {Data: []byte("Message-ID: <3@example.com>\nSubject: Third\n\nNo sender.\n"), ModTime: time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC)},
+		"notes.txt":    {Data: []byte("Not a message.")},
+	}
+
+	tests := []struct {
+		order ImportOrder
+		want  []string
+	}{
+		{ImportByName, []string{
+			"From bounce@example.com Sat Jan  3 00:00:00 2015",
+			"From bob@example.com Thu Jan  1 00:00:00 2015",
+			"From MAILER-DAEMON Fri Jan  2 00:00:00 2015",
+		}},
+		{ImportByDate, []string{
+			"From bob@example.com Thu Jan  1 00:00:00 2015",
+			"From MAILER-DAEMON Fri Jan  2 00:00:00 2015",
+			"From bounce@example.com Sat Jan  3 00:00:00 2015",
+		}},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		n, err := ImportEML(fsys, w, &ImportOptions{Order: tt.order})
+		if err != nil || n != 3 {
+			t.Fatalf("ImportEML() = %d, %v", n, err)
+		}
+		w.Close()
+
+		if !strings.Contains(b.String(), "\n>From the start.\n") {
+			t.Errorf("Expected the body From line to be escaped:\n%s", b.String())
+		}
+
+		r := NewReader(&b)
+		var got []string
+		for {
+			if _, err := r.NextMessage(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Unexpected error after NextMessage(): %v", err)
+			}
+			got = append(got, r.Separator())
+		}
+
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%d - Expected:\n%s\ngot\n%s", tt.order, strings.Join(tt.want, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestImportEMLMalformed(t *testing.T) {
+	mtime := time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.eml":   {Data: []byte("From alice@example.com Thu Jan  1 00:00:00 2015\nFrom: Alice <alice@example.com>\nDate: Thu, 01 Jan 2015 00:00:00 +0000\nSubject: Envelope\n\nHi.\n")},
+		"b.eml":   {Data: []byte("Subject: Broken\nnot a header\n\nHi.\n"), ModTime: mtime},
+		"c.eml":   {Data: []byte("From: Carol <carol@example.com>\nDate: Sat, 03 Jan 2015 00:00:00 +0000\nSubject: Last\n\nHi.\n")},
+		"d.msg":   {Data: []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")},
+		"e/f.txt": {Data: []byte("Not a message.")},
+	}
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	n, err := ImportEML(fsys, w, &ImportOptions{Order: ImportByDate})
+	if err != nil || n != 3 {
+		t.Fatalf("ImportEML() = %d, %v", n, err)
+	}
+	w.Close()
+
+	want := "From alice@example.com Thu Jan  1 00:00:00 2015\nFrom: Alice <alice@example.com>\nDate: Thu, 01 Jan 2015 00:00:00 +0000\nSubject: Envelope\n\nHi.\n\n" +
+		"From MAILER-DAEMON Fri Jan  2 00:00:00 2015\nSubject: Broken\nnot a header\n\nHi.\n\n" +
+		"From carol@example.com Sat Jan  3 00:00:00 2015\n"
+	if !strings.HasPrefix(b.String(), want) {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, b.String())
+	}
+}
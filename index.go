@@ -0,0 +1,825 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const indexMagic = "MBOXIDX2"
+
+// indexFlushDocs is the number of messages whose postings are collected in
+// memory before they are written to a segment file.
+const indexFlushDocs = 20000
+
+// IndexFields lists the fields which are indexed for every message.
+var IndexFields = []string{"from", "to", "cc", "subject", "body"}
+
+var (
+	ErrInvalidIndex = errors.New("invalid index file")
+	ErrIndexStale   = errors.New("index does not match mbox")
+)
+
+// Index is a full-text inverted index of an mbox file, stored in a single
+// file. Postings are keyed by the offset of each message in the mbox, as
+// reported by Reader.Offset. The dictionary is held in memory while postings
+// are read from disk on demand.
+type Index struct {
+	path string
+	f    *os.File
+	size int64
+	docs []int64
+	dict map[string]indexRef
+	// entries is the offset of the first dictionary entry in f.
+	entries int64
+}
+
+type indexRef struct {
+	off int64
+	n   int
+}
+
+// posting lists the positions of a term in one message field.
+type posting struct {
+	doc       int
+	positions []int
+}
+
+// CreateIndex creates an empty index file at path, replacing any existing
+// one. Messages are added with Update.
+func CreateIndex(path string) (*Index, error) {
+	ix := &Index{path: path, dict: map[string]indexRef{}}
+	if err := ix.merge(nil, nil); err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// OpenIndex opens an index file created by CreateIndex.
+func OpenIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &Index{path: path, f: f}
+	if err := ix.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// Size returns the number of mbox bytes covered by the index.
+func (ix *Index) Size() int64 {
+	return ix.size
+}
+
+// Len returns the number of indexed messages.
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+// Close closes the index file.
+func (ix *Index) Close() error {
+	return ix.f.Close()
+}
+
+// Update indexes the messages appended to the mbox read from r since the
+// index was last updated, and writes the index file. It returns
+// ErrIndexStale if r no longer starts with the data that was indexed.
+func (ix *Index) Update(r io.ReadSeeker) (err error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end < ix.size {
+		return ErrIndexStale
+	}
+
+	if n := len(ix.docs); n > 0 {
+		sep := make([]byte, 5)
+		if _, err := r.Seek(ix.docs[n-1], io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, sep); err != nil || string(sep) != "From " {
+			return ErrIndexStale
+		}
+	}
+
+	if _, err := r.Seek(ix.size, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Every indexFlushDocs messages, the postings collected in memory are
+	// written to a segment file. The index file is only replaced once all
+	// messages are indexed, by merging it with the segments.
+	var segments []*os.File
+	defer func() {
+		for _, f := range segments {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	size, docs := ix.size, len(ix.docs)
+	defer func() {
+		if err != nil {
+			ix.size, ix.docs = size, ix.docs[:docs]
+		}
+	}()
+
+	mr := NewReader(r)
+	pending := map[string][]posting{}
+	count := 0
+	for {
+		msg, err := mr.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		doc := len(ix.docs)
+		if err := indexMessage(pending, doc, msg); err != nil {
+			return err
+		}
+		ix.docs = append(ix.docs, size+mr.Offset())
+
+		if count++; count%indexFlushDocs == 0 {
+			f, err := ix.spill(pending)
+			if f != nil {
+				segments = append(segments, f)
+			}
+			if err != nil {
+				return err
+			}
+			pending = map[string][]posting{}
+		}
+	}
+
+	ix.size = end
+
+	return ix.merge(segments, pending)
+}
+
+// indexMessage adds the postings of one message to pending. A message whose
+// header or body cannot be parsed is indexed by the header fields read before
+// the error, without body text.
+func indexMessage(pending map[string][]posting, doc int, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var (
+		h    mail.Header
+		text string
+	)
+	if msg, err := mail.ReadMessage(bytes.NewReader(b)); err == nil {
+		h = msg.Header
+		if t, isHTML, err := extractText(newEntity(textproto.MIMEHeader(h), msg.Body), &TextOptions{}, 0); err == nil {
+			if isHTML {
+				t = htmlToText(t)
+			}
+			text = t
+		}
+	} else {
+		mh, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(b))).ReadMIMEHeader()
+		h = mail.Header(mh)
+	}
+
+	var dec mime.WordDecoder
+	header := func(names ...string) string {
+		var parts []string
+		for _, name := range names {
+			for _, v := range h[name] {
+				if d, err := dec.DecodeHeader(v); err == nil {
+					v = d
+				}
+				parts = append(parts, v)
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+
+	fields := map[string]string{
+		"from":    header("From", "Sender"),
+		"to":      header("To"),
+		"cc":      header("Cc"),
+		"subject": header("Subject"),
+		"body":    text,
+	}
+
+	for field, value := range fields {
+		positions := map[string][]int{}
+		for i, term := range tokenize(value) {
+			positions[term] = append(positions[term], i)
+		}
+
+		for term, pos := range positions {
+			key := field + ":" + term
+			pending[key] = append(pending[key], posting{doc: doc, positions: pos})
+		}
+	}
+
+	return nil
+}
+
+// tokenize splits text into lower-case words of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+}
+
+// Search returns the offsets of the messages matching query, in mbox order.
+// A query is a list of clauses which must all match. A clause is a word, a
+// phrase in double quotes, or either of them prefixed with a field name from
+// IndexFields and a colon (subject:"quarterly report"). Clauses without a
+// field match any field.
+func (ix *Index) Search(query string) ([]int64, error) {
+	clauses, err := parseIndexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []int
+	for i, c := range clauses {
+		matched, err := ix.searchClause(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			docs = matched
+		} else {
+			docs = intersectDocs(docs, matched)
+		}
+	}
+
+	offsets := make([]int64, len(docs))
+	for i, d := range docs {
+		offsets[i] = ix.docs[d]
+	}
+
+	return offsets, nil
+}
+
+type indexClause struct {
+	field string
+	terms []string
+}
+
+func parseIndexQuery(query string) ([]indexClause, error) {
+	var clauses []indexClause
+	for _, tok := range tokenizeQuery(query) {
+		c := indexClause{}
+		if field, value, ok := strings.Cut(tok, ":"); ok {
+			field = strings.ToLower(field)
+			known := false
+			for _, f := range IndexFields {
+				known = known || f == field
+			}
+			if !known {
+				return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, field)
+			}
+			c.field, tok = field, value
+		}
+
+		if c.terms = tokenize(tok); len(c.terms) > 0 {
+			clauses = append(clauses, c)
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidQuery)
+	}
+
+	return clauses, nil
+}
+
+// searchClause returns the sorted documents matching a clause in any of its
+// fields.
+func (ix *Index) searchClause(c indexClause) ([]int, error) {
+	fields := IndexFields
+	if c.field != "" {
+		fields = []string{c.field}
+	}
+
+	seen := map[int]bool{}
+	for _, field := range fields {
+		var matches []posting
+		for i, term := range c.terms {
+			ps, err := ix.postings(field + ":" + term)
+			if err != nil {
+				return nil, err
+			}
+
+			if i == 0 {
+				matches = ps
+			} else {
+				matches = followPostings(matches, ps)
+			}
+		}
+
+		for _, p := range matches {
+			seen[p.doc] = true
+		}
+	}
+
+	docs := make([]int, 0, len(seen))
+	for d := range seen {
+		docs = append(docs, d)
+	}
+	sort.Ints(docs)
+
+	return docs, nil
+}
+
+// followPostings keeps the occurrences in prev which are immediately followed
+// by an occurrence in next, advancing them to the position in next.
+func followPostings(prev, next []posting) []posting {
+	var out []posting
+	i, j := 0, 0
+	for i < len(prev) && j < len(next) {
+		switch {
+		case prev[i].doc < next[j].doc:
+			i++
+		case prev[i].doc > next[j].doc:
+			j++
+		default:
+			at := map[int]bool{}
+			for _, p := range next[j].positions {
+				at[p] = true
+			}
+
+			var pos []int
+			for _, p := range prev[i].positions {
+				if at[p+1] {
+					pos = append(pos, p+1)
+				}
+			}
+			if len(pos) > 0 {
+				out = append(out, posting{doc: prev[i].doc, positions: pos})
+			}
+			i++
+			j++
+		}
+	}
+
+	return out
+}
+
+func intersectDocs(a, b []int) []int {
+	var out []int
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+
+	return out
+}
+
+func (ix *Index) postings(key string) ([]posting, error) {
+	ref, ok := ix.dict[key]
+	if !ok {
+		return nil, nil
+	}
+
+	b := make([]byte, ref.n)
+	if _, err := ix.f.ReadAt(b, ref.off); err != nil {
+		return nil, err
+	}
+
+	return decodePostings(b)
+}
+
+// The index file starts with indexMagic, followed by uvarints: the number of
+// indexed mbox bytes, the number of messages and their delta-encoded offsets.
+// Dictionary entries follow up to the end of the file, in key order. Each
+// entry is the length of its key, the key, the length of its postings and the
+// postings themselves. Postings are the number of messages followed, for each
+// message, by the delta-encoded message number, the number of positions and
+// the delta-encoded positions.
+//
+// Segments, which hold the postings collected by Update between flushes, are
+// made of dictionary entries alone.
+
+func (ix *Index) load() error {
+	fi, err := ix.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	cr := &countingReader{r: ix.f}
+	br := bufio.NewReader(cr)
+
+	// fits reports whether n bytes, or n values of at least one byte, are
+	// left in the file, so that corrupted counts are not allocated.
+	fits := func(n uint64) bool {
+		return n <= uint64(fi.Size()-position(cr, br))
+	}
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != indexMagic {
+		return ErrInvalidIndex
+	}
+
+	var vals [3]uint64
+	read := func(n int) error {
+		for i := 0; i < n; i++ {
+			v, err := binary.ReadUvarint(br)
+			if err != nil {
+				return ErrInvalidIndex
+			}
+			vals[i] = v
+		}
+		return nil
+	}
+
+	if err := read(2); err != nil {
+		return err
+	}
+	if !fits(vals[1]) {
+		return ErrInvalidIndex
+	}
+	ix.size = int64(vals[0])
+	ix.docs = make([]int64, 0, vals[1])
+
+	var last int64
+	for n := vals[1]; n > 0; n-- {
+		if err := read(1); err != nil {
+			return err
+		}
+		last += int64(vals[0])
+		ix.docs = append(ix.docs, last)
+	}
+
+	ix.entries = position(cr, br)
+	ix.dict = map[string]indexRef{}
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+
+		if err := read(1); err != nil {
+			return err
+		}
+		if !fits(vals[0]) {
+			return ErrInvalidIndex
+		}
+		key := make([]byte, vals[0])
+		if _, err := io.ReadFull(br, key); err != nil {
+			return ErrInvalidIndex
+		}
+
+		if err := read(1); err != nil {
+			return err
+		}
+		if !fits(vals[0]) {
+			return ErrInvalidIndex
+		}
+		ix.dict[string(key)] = indexRef{off: position(cr, br), n: int(vals[0])}
+		if _, err := br.Discard(int(vals[0])); err != nil {
+			return ErrInvalidIndex
+		}
+	}
+
+	return nil
+}
+
+// writeSegment writes the postings of pending to w as dictionary entries, in
+// key order.
+func writeSegment(w io.Writer, pending map[string][]posting) error {
+	keys := make([]string, 0, len(pending))
+	for k := range pending {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for _, k := range keys {
+		b := encodePostings(pending[k])
+		buf = binary.AppendUvarint(buf[:0], uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(b)))
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// spill writes pending to a new segment file next to the index.
+func (ix *Index) spill(pending map[string][]posting) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(ix.path), filepath.Base(ix.path)+".seg*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSegment(f, pending); err != nil {
+		return f, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+
+	return f, err
+}
+
+// merge writes a new index file combining the current one with the segments
+// written by spill and pending, and replaces the current file with it. The
+// current file and the segments are read sequentially, merging their entries
+// by key; the postings of a key are concatenated in that order, which is
+// message order.
+func (ix *Index) merge(segments []*os.File, pending map[string][]posting) error {
+	var runs []*indexRun
+	if ix.f != nil {
+		runs = append(runs, &indexRun{r: bufio.NewReader(io.NewSectionReader(ix.f, ix.entries, math.MaxInt64-ix.entries))})
+	}
+	for _, f := range segments {
+		runs = append(runs, &indexRun{r: bufio.NewReader(f)})
+	}
+	if len(pending) > 0 {
+		var b bytes.Buffer
+		writeSegment(&b, pending)
+		runs = append(runs, &indexRun{r: bufio.NewReader(&b)})
+	}
+
+	h := &indexHeap{}
+	for i, run := range runs {
+		run.seq = i
+		if err := run.next(); err != nil {
+			return err
+		}
+		if !run.done {
+			h.runs = append(h.runs, run)
+		}
+	}
+	heap.Init(h)
+
+	tmp, err := os.CreateTemp(filepath.Dir(ix.path), filepath.Base(ix.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	cw := &countingWriter{w: w}
+
+	var buf [binary.MaxVarintLen64]byte
+	put := func(v uint64) {
+		cw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+
+	io.WriteString(cw, indexMagic)
+	put(uint64(ix.size))
+	put(uint64(len(ix.docs)))
+	var last int64
+	for _, d := range ix.docs {
+		put(uint64(d - last))
+		last = d
+	}
+
+	entries := cw.n
+	dict := map[string]indexRef{}
+	for h.Len() > 0 {
+		key := h.runs[0].key
+
+		// Gather the postings of key from every run holding it.
+		var lists [][]byte
+		for h.Len() > 0 && h.runs[0].key == key {
+			run := h.runs[0]
+			lists = append(lists, run.postings)
+			if err := run.next(); err != nil {
+				tmp.Close()
+				return err
+			}
+			if run.done {
+				heap.Pop(h)
+			} else {
+				heap.Fix(h, 0)
+			}
+		}
+
+		b := lists[0]
+		if len(lists) > 1 {
+			var ps []posting
+			for _, l := range lists {
+				p, err := decodePostings(l)
+				if err != nil {
+					tmp.Close()
+					return err
+				}
+				ps = append(ps, p...)
+			}
+			b = encodePostings(ps)
+		}
+
+		put(uint64(len(key)))
+		io.WriteString(cw, key)
+		put(uint64(len(b)))
+		dict[key] = indexRef{off: cw.n, n: len(b)}
+		cw.Write(b)
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), ix.path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(ix.path)
+	if err != nil {
+		return err
+	}
+
+	if ix.f != nil {
+		ix.f.Close()
+	}
+	ix.f, ix.dict, ix.entries = f, dict, entries
+
+	return nil
+}
+
+// indexRun reads the dictionary entries of an index file or a segment.
+type indexRun struct {
+	r        *bufio.Reader
+	seq      int
+	key      string
+	postings []byte
+	done     bool
+}
+
+func (run *indexRun) next() error {
+	n, err := binary.ReadUvarint(run.r)
+	if err == io.EOF {
+		run.done = true
+		return nil
+	}
+
+	var key, postings []byte
+	if err == nil {
+		key = make([]byte, n)
+		_, err = io.ReadFull(run.r, key)
+	}
+	if err == nil {
+		n, err = binary.ReadUvarint(run.r)
+	}
+	if err == nil {
+		postings = make([]byte, n)
+		_, err = io.ReadFull(run.r, postings)
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidIndex
+	} else if err != nil {
+		return err
+	}
+
+	run.key, run.postings = string(key), postings
+
+	return nil
+}
+
+// indexHeap orders runs by their current key, then by their order.
+type indexHeap struct {
+	runs []*indexRun
+}
+
+func (h *indexHeap) Len() int { return len(h.runs) }
+
+func (h *indexHeap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+	if a.key != b.key {
+		return a.key < b.key
+	}
+
+	return a.seq < b.seq
+}
+
+func (h *indexHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *indexHeap) Push(x any)    { h.runs = append(h.runs, x.(*indexRun)) }
+
+func (h *indexHeap) Pop() any {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+
+	return run
+}
+
+func encodePostings(ps []posting) []byte {
+	var (
+		b    bytes.Buffer
+		buf  [binary.MaxVarintLen64]byte
+		last int
+	)
+	put := func(v int) {
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(v))])
+	}
+
+	put(len(ps))
+	for _, p := range ps {
+		put(p.doc - last)
+		last = p.doc
+
+		put(len(p.positions))
+		prev := 0
+		for _, pos := range p.positions {
+			put(pos - prev)
+			prev = pos
+		}
+	}
+
+	return b.Bytes()
+}
+
+func decodePostings(b []byte) ([]posting, error) {
+	r := bytes.NewReader(b)
+	get := func() int {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return -1
+		}
+		return int(v)
+	}
+
+	n := get()
+	if n < 0 || n > len(b) {
+		return nil, ErrInvalidIndex
+	}
+
+	ps := make([]posting, n)
+	last := 0
+	for i := range ps {
+		d, np := get(), get()
+		if d < 0 || np < 0 || np > len(b) {
+			return nil, ErrInvalidIndex
+		}
+		last += d
+
+		ps[i] = posting{doc: last, positions: make([]int, np)}
+		prev := 0
+		for j := range ps[i].positions {
+			v := get()
+			if v < 0 {
+				return nil, ErrInvalidIndex
+			}
+			prev += v
+			ps[i].positions[j] = prev
+		}
+	}
+
+	return ps, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
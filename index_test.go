@@ -0,0 +1,260 @@
+package mbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	mboxPath := filepath.Join(dir, "mbox")
+	indexPath := filepath.Join(dir, "mbox.idx")
+
+	// Index the first two messages, then append the third one.
+	split := strings.Index(mboxQuery, "From carol@example.com")
+	if err := os.WriteFile(mboxPath, []byte(mboxQuery[:split]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := CreateIndex(indexPath)
+	if err != nil {
+		t.Fatalf("CreateIndex() = %v", err)
+	}
+
+	update := func() {
+		f, err := os.Open(mboxPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if err := ix.Update(f); err != nil {
+			t.Fatalf("Update() = %v", err)
+		}
+	}
+
+	update()
+	if ix.Len() != 2 {
+		t.Fatalf("Expected 2 indexed messages, got %d", ix.Len())
+	}
+
+	f, err := os.OpenFile(mboxPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(mboxQuery[split:])
+	f.Close()
+
+	update()
+	ix.Close()
+
+	ix, err = OpenIndex(indexPath)
+	if err != nil {
+		t.Fatalf("OpenIndex() = %v", err)
+	}
+	defer ix.Close()
+
+	if ix.Len() != 3 || ix.Size() != int64(len(mboxQuery)) {
+		t.Fatalf("Unexpected index state: %d messages, %d bytes", ix.Len(), ix.Size())
+	}
+
+	offsets := []int64{0, int64(strings.Index(mboxQuery, "From bob@")), int64(split)}
+	tests := map[string][]int64{
+		"quarterly":                  offsets[:2],
+		"subject:quarterly":          offsets[:2],
+		"body:quarterly":             nil,
+		`"numbers look good"`:        offsets[1:2],
+		`"good numbers"`:             nil,
+		"alice":                      offsets,
+		"from:alice":                 offsets[:1],
+		"to:alice pizza":             offsets[2:],
+		`subject:"quarterly report"`: offsets[:2],
+		"figures":                    offsets[:1],
+		"böb":                        offsets[1:2],
+	}
+
+	for query, want := range tests {
+		got, err := ix.Search(query)
+		if err != nil {
+			t.Fatalf("Search(%q) = %v", query, err)
+		}
+		if len(got) != 0 || len(want) != 0 {
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Search(%q) = %v, want %v", query, got, want)
+			}
+		}
+	}
+
+	if _, err := ix.Search("date:today"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestIndexStale(t *testing.T) {
+	dir := t.TempDir()
+	mboxPath := filepath.Join(dir, "mbox")
+	if err := os.WriteFile(mboxPath, []byte(mboxQuery), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := CreateIndex(filepath.Join(dir, "mbox.idx"))
+	if err != nil {
+		t.Fatalf("CreateIndex() = %v", err)
+	}
+	defer ix.Close()
+
+	f, err := os.Open(mboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ix.Update(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+
+	if err := os.WriteFile(mboxPath, []byte(mboxQuery[10:]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(mboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ix.Update(f); err != ErrIndexStale {
+		t.Errorf("Expected ErrIndexStale, got %v", err)
+	}
+}
+
+func TestOpenIndexCorrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	var huge [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(huge[:], 1<<62)
+
+	tests := map[string][]byte{
+		"magic":     []byte("MBOXIDX0\x00\x00\x00"),
+		"truncated": []byte(indexMagic + "\x05"),
+		"docs":      append([]byte(indexMagic+"\x00"), huge[:n]...),
+		"key":       append([]byte(indexMagic+"\x00\x00"), huge[:n]...),
+		"postings":  append([]byte(indexMagic+"\x00\x00\x01k"), huge[:n]...),
+		"entry":     []byte(indexMagic + "\x00\x00\x01k\x05\x01"),
+	}
+
+	for name, data := range tests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenIndex(path); err != ErrInvalidIndex {
+			t.Errorf("%s - Expected ErrInvalidIndex, got %v", name, err)
+		}
+	}
+}
+
+func TestIndexMalformedMessage(t *testing.T) {
+	dir := t.TempDir()
+
+	ix, err := CreateIndex(filepath.Join(dir, "mbox.idx"))
+	if err != nil {
+		t.Fatalf("CreateIndex() = %v", err)
+	}
+	defer ix.Close()
+
+	data := "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: broken header\nnot a header\n\nlost\n\n" +
+		"From b@example.com Thu Jan  1 00:00:01 2015\nSubject: broken body\nContent-Type: multipart/mixed; boundary=x\n\n--x\nContent-Type: text/plain\n\nunterminated\n\n" +
+		mboxQuery
+	if err := ix.Update(strings.NewReader(data)); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+
+	if ix.Len() != 5 || ix.Size() != int64(len(data)) {
+		t.Fatalf("Unexpected index state: %d messages, %d bytes", ix.Len(), ix.Size())
+	}
+
+	tests := map[string]int{"subject:broken": 2, "lost": 0, "quarterly": 2}
+	for query, want := range tests {
+		if got, err := ix.Search(query); err != nil || len(got) != want {
+			t.Errorf("Search(%q) = %v, %v, want %d results", query, got, err, want)
+		}
+	}
+}
+
+// failingReader fails reads past the first n bytes.
+type failingReader struct {
+	*strings.Reader
+	n int64
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	pos, _ := r.Seek(0, io.SeekCurrent)
+	if pos >= r.n {
+		return 0, errors.New("read failed")
+	}
+	if int64(len(p)) > r.n-pos {
+		p = p[:r.n-pos]
+	}
+
+	return r.Reader.Read(p)
+}
+
+func TestIndexSegments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mbox.idx")
+	ix, err := CreateIndex(path)
+	if err != nil {
+		t.Fatalf("CreateIndex() = %v", err)
+	}
+
+	const msg = "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: test\n\nHello.\n\n"
+	const last = "From b@example.com Thu Jan  1 00:00:01 2015\nFrom: b@example.com\nSubject: last\n\nHello again.\n"
+	data := strings.Repeat(msg, 5)
+
+	if err := ix.Update(strings.NewReader(data)); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+
+	// A failed update leaves the index as it was.
+	data += strings.Repeat(msg, 2*indexFlushDocs) + last
+	if err := ix.Update(&failingReader{strings.NewReader(data), int64(len(data) - len(last))}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if ix.Len() != 5 || ix.Size() != int64(5*len(msg)) {
+		t.Fatalf("Unexpected index state: %d messages, %d bytes", ix.Len(), ix.Size())
+	}
+
+	// The next one merges the index with two segments and the last message.
+	if err := ix.Update(strings.NewReader(data)); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	ix.Close()
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("Expected only the index file, got %v", files)
+	}
+
+	ix, err = OpenIndex(path)
+	if err != nil {
+		t.Fatalf("OpenIndex() = %v", err)
+	}
+	defer ix.Close()
+	if n := 5 + 2*indexFlushDocs + 1; ix.Len() != n || ix.Size() != int64(len(data)) {
+		t.Fatalf("Unexpected index state: %d messages, %d bytes", ix.Len(), ix.Size())
+	}
+
+	if got, err := ix.Search("hello"); err != nil || len(got) != ix.Len() || got[len(got)-1] != int64(len(data)-len(last)) {
+		t.Errorf("Search() = %d results, %v", len(got), err)
+	}
+	if got, err := ix.Search("subject:last again"); err != nil || len(got) != 1 {
+		t.Errorf("Search() = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,270 @@
+package mbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLockTimeout is how long Lock waits for a mailbox by default.
+	DefaultLockTimeout = 30 * time.Second
+	// DefaultStaleLock is the age after which a dotlock is considered left
+	// behind by a crashed program, like Postfix's stale_lock_time.
+	DefaultStaleLock = 5 * time.Minute
+
+	// lockRetry is the delay between two attempts to take a lock.
+	lockRetry = 50 * time.Millisecond
+)
+
+var (
+	ErrLockTimeout     = errors.New("timed out waiting for mailbox lock")
+	ErrLockUnsupported = errors.New("lock method not supported on this platform")
+)
+
+// LockMethod selects a way of locking a mailbox. Methods are combined with
+// '|'; all of them are taken, as mail delivery agents and clients may honour
+// only one.
+type LockMethod int
+
+const (
+	// LockDotlock creates a "<mailbox>.lock" file next to the mailbox. The
+	// directory must be writable.
+	LockDotlock LockMethod = 1 << iota
+	// LockFcntl takes a POSIX fcntl lock on the mailbox file.
+	LockFcntl
+	// LockFlock takes a BSD flock lock on the mailbox file.
+	LockFlock
+)
+
+// DefaultLockMethods are the methods used when none are given: dotlock and
+// fcntl on Unix systems, as Postfix and mutt use by default on Linux, and
+// dotlock elsewhere.
+const DefaultLockMethods = platformLockMethods
+
+// LockOptions configures Lock.
+type LockOptions struct {
+	// Methods are the lock methods to use. It defaults to
+	// DefaultLockMethods.
+	Methods LockMethod
+
+	// Timeout is how long to wait for the locks. It defaults to
+	// DefaultLockTimeout; a negative Timeout gives up at once.
+	Timeout time.Duration
+
+	// StaleAfter is the age after which a dotlock is removed. It defaults
+	// to DefaultStaleLock. A dotlock whose process is known to have exited
+	// is removed at once.
+	StaleAfter time.Duration
+
+	// Shared takes shared fcntl and flock locks, for reading. Dotlocks are
+	// always exclusive.
+	Shared bool
+}
+
+// MailboxLock is a lock held on a mailbox.
+type MailboxLock struct {
+	f       *os.File
+	methods LockMethod
+	dotlock string
+}
+
+// Lock locks the mailbox open as f, which must have been opened for writing
+// for exclusive fcntl locks. The dotlock is named after f.Name(). Locks are
+// retried until opts.Timeout expires, in which case it returns
+// ErrLockTimeout. A nil opts uses the defaults.
+func Lock(f *os.File, opts *LockOptions) (*MailboxLock, error) {
+	if opts == nil {
+		opts = &LockOptions{}
+	}
+
+	methods, timeout, stale := opts.Methods, opts.Timeout, opts.StaleAfter
+	if methods == 0 {
+		methods = DefaultLockMethods
+	}
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	if stale == 0 {
+		stale = DefaultStaleLock
+	}
+
+	deadline := time.Now().Add(timeout)
+	l := &MailboxLock{f: f}
+
+	// Take the kernel locks first, as Postfix does, so that the dotlock is
+	// held as briefly as possible.
+	for _, m := range []LockMethod{LockFcntl, LockFlock, LockDotlock} {
+		if methods&m == 0 {
+			continue
+		}
+
+		for {
+			var (
+				ok  bool
+				err error
+			)
+			switch m {
+			case LockFcntl:
+				ok, err = lockFcntl(f, opts.Shared)
+			case LockFlock:
+				ok, err = lockFlock(f, opts.Shared)
+			case LockDotlock:
+				ok, err = l.lockDotlock(f.Name()+".lock", stale)
+			}
+
+			if err != nil {
+				l.Unlock()
+				return nil, err
+			}
+			if ok {
+				l.methods |= m
+				break
+			}
+
+			if time.Now().Add(lockRetry).After(deadline) {
+				l.Unlock()
+				return nil, ErrLockTimeout
+			}
+			time.Sleep(lockRetry)
+		}
+	}
+
+	return l, nil
+}
+
+// lockDotlock tries to create the dotlock at path, removing it first if it
+// is stale.
+func (l *MailboxLock) lockDotlock(path string, stale time.Duration) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			if attempt > 0 || !dotlockStale(path, stale) {
+				return false, nil
+			}
+
+			// Another process may remove it at the same time.
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			continue
+		} else if err != nil {
+			return false, fmt.Errorf("creating dotlock: %w", err)
+		}
+
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return false, err
+		}
+
+		l.dotlock = path
+
+		return true, nil
+	}
+}
+
+// dotlockStale reports whether the dotlock at path is older than stale, or
+// was created by a process which no longer exists.
+func dotlockStale(path string, stale time.Duration) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if time.Since(info.ModTime()) > stale {
+		return true
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		// Lock files may be empty, as some programs write no PID.
+		return false
+	}
+
+	return !processAlive(pid)
+}
+
+// Refresh updates the modification time of the dotlock, so that a lock held
+// for long is not taken as stale.
+func (l *MailboxLock) Refresh() error {
+	if l.dotlock == "" {
+		return nil
+	}
+
+	now := time.Now()
+
+	return os.Chtimes(l.dotlock, now, now)
+}
+
+// Unlock releases the locks.
+func (l *MailboxLock) Unlock() error {
+	var err error
+	if l.dotlock != "" {
+		if rerr := os.Remove(l.dotlock); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			err = rerr
+		}
+		l.dotlock = ""
+	}
+	if l.methods&LockFlock != 0 {
+		if uerr := unlockFlock(l.f); err == nil {
+			err = uerr
+		}
+	}
+	if l.methods&LockFcntl != 0 {
+		if uerr := unlockFcntl(l.f); err == nil {
+			err = uerr
+		}
+	}
+	l.methods = 0
+
+	return err
+}
+
+// LockedFile is a mailbox file open under a lock.
+type LockedFile struct {
+	*os.File
+	lock *MailboxLock
+}
+
+// OpenLocked opens the mailbox at path like os.OpenFile and locks it. Readers
+// should set opts.Shared and open the file read-only; writers should open it
+// with os.O_APPEND.
+func OpenLocked(path string, flag int, perm os.FileMode, opts *LockOptions) (*LockedFile, error) {
+	f, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := Lock(f, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &LockedFile{File: f, lock: l}, nil
+}
+
+// Lock returns the lock held on the file.
+func (f *LockedFile) Lock() *MailboxLock {
+	return f.lock
+}
+
+// Close unlocks and closes the file.
+func (f *LockedFile) Close() error {
+	err := f.lock.Unlock()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
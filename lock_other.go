@@ -0,0 +1,29 @@
+//go:build !unix
+
+package mbox
+
+import "os"
+
+const platformLockMethods = LockDotlock
+
+func lockFcntl(f *os.File, shared bool) (bool, error) {
+	return false, ErrLockUnsupported
+}
+
+func unlockFcntl(f *os.File) error {
+	return ErrLockUnsupported
+}
+
+func lockFlock(f *os.File, shared bool) (bool, error) {
+	return false, ErrLockUnsupported
+}
+
+func unlockFlock(f *os.File) error {
+	return ErrLockUnsupported
+}
+
+// processAlive reports whether the process pid exists. It cannot be told
+// here, so dotlocks are only taken as stale by age.
+func processAlive(pid int) bool {
+	return true
+}
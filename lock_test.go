@@ -0,0 +1,262 @@
+//go:build unix
+
+package mbox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLockHelperProcess is not a test: it is run in a child process by the
+// lock tests to hold a lock while the test process tries to take it.
+func TestLockHelperProcess(t *testing.T) {
+	path := os.Getenv("MBOX_LOCK_HELPER_PATH")
+	if path == "" {
+		return
+	}
+
+	methods, _ := strconv.Atoi(os.Getenv("MBOX_LOCK_HELPER_METHODS"))
+	shared := os.Getenv("MBOX_LOCK_HELPER_SHARED") != ""
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	l, err := Lock(f, &LockOptions{Methods: LockMethod(methods), Shared: shared, Timeout: 5 * time.Second})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+
+	// Hold the lock until the test process closes stdin.
+	io.Copy(io.Discard, os.Stdin)
+
+	if err := l.Unlock(); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+type lockHelper struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// startLockHelper starts a process holding a lock on path.
+func startLockHelper(t *testing.T, path string, methods LockMethod, shared bool) *lockHelper {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		"MBOX_LOCK_HELPER_PATH="+path,
+		"MBOX_LOCK_HELPER_METHODS="+strconv.Itoa(int(methods)))
+	if shared {
+		cmd.Env = append(cmd.Env, "MBOX_LOCK_HELPER_SHARED=1")
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "locked\n" {
+		stdin.Close()
+		cmd.Wait()
+		t.Fatalf("Helper process failed: %q, %v", line, err)
+	}
+
+	h := &lockHelper{cmd: cmd, stdin: stdin}
+	t.Cleanup(func() { h.stop(t) })
+
+	return h
+}
+
+// stop makes the helper release its lock and exit.
+func (h *lockHelper) stop(t *testing.T) {
+	if h.stdin == nil {
+		return
+	}
+
+	h.stdin.Close()
+	h.stdin = nil
+	if err := h.cmd.Wait(); err != nil {
+		t.Errorf("Helper process failed: %v", err)
+	}
+}
+
+func writeLockTestFile(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "spool")
+	if err := os.WriteFile(path, []byte(mboxWithOneMessage), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLockTwoProcesses(t *testing.T) {
+	for _, methods := range []LockMethod{LockDotlock, LockFcntl, LockFlock, DefaultLockMethods, LockDotlock | LockFcntl | LockFlock} {
+		path := writeLockTestFile(t)
+		h := startLockHelper(t, path, methods, false)
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		if _, err := Lock(f, &LockOptions{Methods: methods, Timeout: 200 * time.Millisecond}); err != ErrLockTimeout {
+			t.Errorf("%d - Expected ErrLockTimeout, got %v", methods, err)
+		}
+		if d := time.Since(start); d < 150*time.Millisecond || d > 2*time.Second {
+			t.Errorf("%d - Lock() gave up after %v", methods, d)
+		}
+
+		// The lock is taken once the other process releases it.
+		stdin := h.stdin
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			stdin.Close()
+		}()
+
+		l, err := Lock(f, &LockOptions{Methods: methods, Timeout: 5 * time.Second})
+		if err != nil {
+			t.Fatalf("%d - Lock() = %v", methods, err)
+		}
+		h.stop(t)
+
+		_, err = os.Stat(path + ".lock")
+		if hasDotlock := err == nil; hasDotlock != (methods&LockDotlock != 0) {
+			t.Errorf("%d - Unexpected dotlock presence: %v", methods, err)
+		}
+
+		if err := l.Unlock(); err != nil {
+			t.Errorf("%d - Unlock() = %v", methods, err)
+		}
+		if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+			t.Errorf("%d - Dotlock left behind: %v", methods, err)
+		}
+		f.Close()
+	}
+}
+
+func TestLockShared(t *testing.T) {
+	for _, methods := range []LockMethod{LockFcntl, LockFlock} {
+		path := writeLockTestFile(t)
+		startLockHelper(t, path, methods, true)
+
+		f, err := OpenLocked(path, os.O_RDONLY, 0, &LockOptions{Methods: methods, Shared: true, Timeout: -1})
+		if err != nil {
+			t.Fatalf("%d - OpenLocked() = %v", methods, err)
+		}
+
+		msg, err := NewReader(f).NextMessage()
+		if err != nil {
+			t.Fatalf("%d - Unexpected error after NextMessage(): %v", methods, err)
+		}
+		io.Copy(io.Discard, msg)
+
+		if err := f.Close(); err != nil {
+			t.Errorf("%d - Close() = %v", methods, err)
+		}
+
+		// Writers still have to wait for the reader in the other process.
+		if _, err := OpenLocked(path, os.O_RDWR|os.O_APPEND, 0, &LockOptions{Methods: methods, Timeout: -1}); err != ErrLockTimeout {
+			t.Errorf("%d - Expected ErrLockTimeout, got %v", methods, err)
+		}
+	}
+}
+
+func TestLockStaleDotlock(t *testing.T) {
+	path := writeLockTestFile(t)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	opts := &LockOptions{Methods: LockDotlock, Timeout: -1, StaleAfter: time.Minute}
+
+	// A fresh lock without a PID is honoured.
+	if err := os.WriteFile(path+".lock", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(f, opts); err != ErrLockTimeout {
+		t.Errorf("Expected ErrLockTimeout, got %v", err)
+	}
+
+	// An old lock is removed.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Lock(f, opts)
+	if err != nil {
+		t.Fatalf("Lock() = %v", err)
+	}
+	l.Unlock()
+
+	// A lock left by a process which exited is removed.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".lock", []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Lock(f, opts)
+	if err != nil {
+		t.Fatalf("Lock() = %v", err)
+	}
+
+	b, err := os.ReadFile(path + ".lock")
+	if err != nil || strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Unexpected dotlock content %q, %v", b, err)
+	}
+	l.Unlock()
+}
+
+func TestAppendLocked(t *testing.T) {
+	path := writeLockTestFile(t)
+	h := startLockHelper(t, path, DefaultLockMethods, false)
+
+	msg := "From: e@example.com\nSubject: Appended\n\nHi.\n"
+	opts := &AppendOptions{Lock: &LockOptions{Timeout: 100 * time.Millisecond}}
+	if _, err := Append(path, "", time.Time{}, strings.NewReader(msg), opts); err != ErrLockTimeout {
+		t.Errorf("Expected ErrLockTimeout, got %v", err)
+	}
+
+	h.stop(t)
+
+	if _, err := Append(path, "", time.Time{}, strings.NewReader(msg), opts); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), msg+"\n") {
+		t.Errorf("Message not appended:\n%s", b)
+	}
+}
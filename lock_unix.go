@@ -0,0 +1,54 @@
+//go:build unix
+
+package mbox
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const platformLockMethods = LockDotlock | LockFcntl
+
+func lockFcntl(f *os.File, shared bool) (bool, error) {
+	typ := int16(syscall.F_WRLCK)
+	if shared {
+		typ = syscall.F_RDLCK
+	}
+
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: typ, Whence: 0})
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFcntl(f *os.File) error {
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_UNLCK, Whence: 0})
+}
+
+func lockFlock(f *os.File, shared bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// processAlive reports whether the process pid exists on this host.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,313 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNotMaildir = errors.New("not a maildir")
+
+// maildirSeq makes Maildir file names unique within the process.
+var maildirSeq atomic.Uint64
+
+// MaildirMessage describes a message file in a Maildir.
+type MaildirMessage struct {
+	// Path is the path of the message file.
+	Path string
+	// New reports whether the message is in new/, i.e. has not been seen by
+	// a mail client yet.
+	New bool
+	// Flags are the Maildir info flags of the message, such as "RS".
+	Flags string
+	// ModTime is the delivery time of the message.
+	ModTime time.Time
+}
+
+// MaildirReader reads the messages of a Maildir: first those in new/, then
+// those in cur/, each in file name order.
+type MaildirReader struct {
+	msgs []*MaildirMessage
+	cur  *MaildirMessage
+	f    *os.File
+}
+
+// NewMaildirReader returns a MaildirReader for the Maildir at dir.
+func NewMaildirReader(dir string) (*MaildirReader, error) {
+	r := &MaildirReader{}
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotMaildir
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+
+			m := &MaildirMessage{Path: filepath.Join(dir, sub, e.Name()), New: sub == "new", ModTime: info.ModTime()}
+			if _, flags, ok := strings.Cut(e.Name(), ":2,"); ok && sub == "cur" {
+				m.Flags = flags
+			}
+			r.msgs = append(r.msgs, m)
+		}
+	}
+
+	// Keep new/ before cur/ and sort each by name, which starts with the
+	// delivery time.
+	sort.SliceStable(r.msgs, func(i, j int) bool {
+		if r.msgs[i].New != r.msgs[j].New {
+			return r.msgs[i].New
+		}
+		return r.msgs[i].Path < r.msgs[j].Path
+	})
+
+	return r, nil
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (r *MaildirReader) NextMessage() (io.Reader, error) {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+
+	if len(r.msgs) == 0 {
+		r.cur = nil
+		return nil, io.EOF
+	}
+
+	r.cur, r.msgs = r.msgs[0], r.msgs[1:]
+
+	f, err := os.Open(r.cur.Path)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+
+	return f, nil
+}
+
+// Message describes the message most recently returned by NextMessage.
+func (r *MaildirReader) Message() *MaildirMessage {
+	return r.cur
+}
+
+// Close closes the file of the current message.
+func (r *MaildirReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
+
+// MaildirWriter delivers messages to a Maildir.
+type MaildirWriter struct {
+	dir  string
+	host string
+}
+
+// NewMaildirWriter returns a MaildirWriter for the Maildir at dir, creating
+// its cur, new and tmp subdirectories if needed.
+func NewMaildirWriter(dir string) (*MaildirWriter, error) {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+
+	return &MaildirWriter{dir: dir, host: host}, nil
+}
+
+// uniqueName returns a file name following the Maildir conventions:
+// the delivery time, then microseconds, process ID and a sequence number,
+// then the host name.
+func (w *MaildirWriter) uniqueName(now time.Time) string {
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirSeq.Add(1), w.host)
+}
+
+// WriteMessage delivers the message read from r. The message is written to
+// tmp/ and then moved to new/, or to cur/ with the given info flags when seen
+// is true. A non-zero date is used as the modification time of the file. It
+// returns the path of the delivered file.
+func (w *MaildirWriter) WriteMessage(r io.Reader, seen bool, flags string, date time.Time) (string, error) {
+	name := w.uniqueName(time.Now())
+	tmp := filepath.Join(w.dir, "tmp", name)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !date.IsZero() {
+		err = os.Chtimes(tmp, date, date)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	dst := filepath.Join(w.dir, "new", name)
+	if seen {
+		dst = filepath.Join(w.dir, "cur", name+":2,"+sortFlags(flags))
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// sortFlags returns Maildir info flags in ASCII order without duplicates, as
+// the Maildir specification requires.
+func sortFlags(flags string) string {
+	b := []byte(flags)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+
+	var out []byte
+	for i, c := range b {
+		if i == 0 || c != b[i-1] {
+			out = append(out, c)
+		}
+	}
+
+	return string(out)
+}
+
+// MboxToMaildir converts the messages read from r, an mbox of variant v, into
+// the Maildir at dir. Status and X-Status headers become Maildir flags, the
+// envelope sender is kept as a Return-Path header and the separator date as
+// the file modification time. Messages with a malformed header are converted
+// as they are. It returns the number of converted messages.
+func MboxToMaildir(r *Reader, dir string, v Variant) (int, error) {
+	w, err := NewMaildirWriter(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return n, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		h, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+		if err != nil {
+			// The header is malformed: convert the message as it is.
+			h = &mail.Message{Header: mail.Header{}}
+		}
+
+		f := HeaderFlags(h.Header)
+		header = headerDel(header, "Status", "X-Status")
+
+		sender, date, _ := ParseSeparator(r.Separator())
+		if sender != "" && h.Header.Get("Return-Path") == "" {
+			header = append([]byte("Return-Path: <"+sender+">\n"), header...)
+		}
+		if date.IsZero() {
+			date, _ = h.Header.Date()
+		}
+
+		if _, err := w.WriteMessage(bytes.NewReader(joinMessage(header, v.unescape(body))), f&(FlagSeen|FlagOld) != 0, f.Maildir(), date); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// MaildirToMbox writes the messages of the Maildir at dir to w. Maildir flags
+// become Status and X-Status headers, the Return-Path header provides the
+// envelope sender and the file modification time the separator date. It
+// returns the number of converted messages.
+func MaildirToMbox(dir string, w *Writer) (int, error) {
+	r, err := NewMaildirReader(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	n := 0
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return n, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		h, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+		if err != nil {
+			// The header is malformed: convert the message as it is.
+			h = &mail.Message{Header: mail.Header{}}
+		}
+
+		m := r.Message()
+		status, xstatus := ParseMaildirFlags(m.Flags, !m.New).Status()
+		header = headerDel(header, "Status", "X-Status")
+		header = headerAdd(header, "Status", status)
+		header = headerAdd(header, "X-Status", xstatus)
+
+		var sender string
+		if addr, err := mail.ParseAddress(h.Header.Get("Return-Path")); err == nil {
+			sender = addr.Address
+		} else {
+			sender = strings.Trim(strings.TrimSpace(h.Header.Get("Return-Path")), "<>")
+		}
+
+		if err := w.WriteMessage(sender, m.ModTime, bytes.NewReader(joinMessage(header, body))); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
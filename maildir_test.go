@@ -0,0 +1,152 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mboxWithStatus = `From alice@example.com Thu Jan  1 00:00:01 2015
+From: alice@example.com
+Subject: Unread
+
+>From the top.
+
+From bob@example.com Fri Jan  2 00:00:01 2015
+From: bob@example.com
+Subject: Read and answered
+Status: RO
+X-Status: AF
+
+Done.
+
+From carol@example.com Sat Jan  3 00:00:01 2015
+From: carol@example.com
+Subject: Old
+Status: O
+
+Later.
+`
+
+func TestMaildirRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+
+	n, err := MboxToMaildir(NewReader(strings.NewReader(mboxWithStatus)), dir, Mboxrd)
+	if err != nil || n != 3 {
+		t.Fatalf("MboxToMaildir() = %d, %v", n, err)
+	}
+
+	r, err := NewMaildirReader(dir)
+	if err != nil {
+		t.Fatalf("NewMaildirReader() = %v", err)
+	}
+
+	var got []string
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+
+		info := r.Message()
+		if info.ModTime.Year() != 2015 {
+			t.Errorf("Expected the separator date as modification time, got %v", info.ModTime)
+		}
+		if m.Header.Get("Status") != "" {
+			t.Errorf("Status header was not removed from %s", info.Path)
+		}
+
+		got = append(got, m.Header.Get("Subject")+":"+info.Flags+":"+m.Header.Get("Return-Path")+":"+strings.TrimSpace(filepath.Base(filepath.Dir(info.Path))))
+	}
+	r.Close()
+
+	want := "Unread::<alice@example.com>:new|Read and answered:FRS:<bob@example.com>:cur|Old::<carol@example.com>:cur"
+	if strings.Join(got, "|") != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, strings.Join(got, "|"))
+	}
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if n, err := MaildirToMbox(dir, w); err != nil || n != 3 {
+		t.Fatalf("MaildirToMbox() = %d, %v", n, err)
+	}
+	w.Close()
+
+	out := b.String()
+	for _, s := range []string{
+		"From alice@example.com Thu Jan  1 00:00:01 2015\nReturn-Path: <alice@example.com>\nFrom: alice@example.com\nSubject: Unread\n\n>From the top.\n",
+		"From bob@example.com Fri Jan  2 00:00:01 2015\n",
+		"Status: RO\nX-Status: AF\n",
+		"Subject: Old\nStatus: O\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected output to contain %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestMaildirMalformedHeader(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	data := "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: Broken\nnot a header\n\nBody.\n\n" + mboxWithStatus
+
+	if n, err := MboxToMaildir(NewReader(strings.NewReader(data)), dir, Mboxrd); err != nil || n != 4 {
+		t.Fatalf("MboxToMaildir() = %d, %v", n, err)
+	}
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if n, err := MaildirToMbox(dir, w); err != nil || n != 4 {
+		t.Fatalf("MaildirToMbox() = %d, %v", n, err)
+	}
+	w.Close()
+
+	if want := "Return-Path: <a@example.com>\nFrom: a@example.com\nSubject: Broken\nnot a header\n\nBody.\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Expected output to contain %q:\n%s", want, b.String())
+	}
+}
+
+func TestMaildirWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewMaildirWriter(dir)
+	if err != nil {
+		t.Fatalf("NewMaildirWriter() = %v", err)
+	}
+
+	date := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	p1, err := w.WriteMessage(strings.NewReader("Subject: a\n\nA\n"), true, "SRS", date)
+	if err != nil {
+		t.Fatalf("WriteMessage() = %v", err)
+	}
+	p2, err := w.WriteMessage(strings.NewReader("Subject: b\n\nB\n"), false, "", time.Time{})
+	if err != nil {
+		t.Fatalf("WriteMessage() = %v", err)
+	}
+
+	if p1 == p2 || !strings.HasSuffix(p1, ":2,RS") || filepath.Base(filepath.Dir(p2)) != "new" {
+		t.Errorf("Unexpected paths %q, %q", p1, p2)
+	}
+
+	if fi, err := os.Stat(p1); err != nil || !fi.ModTime().Equal(date) {
+		t.Errorf("Unexpected modification time: %v, %v", fi, err)
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(entries) != 0 {
+		t.Errorf("Expected tmp/ to be empty, got %d entries", len(entries))
+	}
+
+	if _, err := NewMaildirReader(filepath.Join(dir, "missing")); err != ErrNotMaildir {
+		t.Errorf("Expected ErrNotMaildir, got %v", err)
+	}
+}
@@ -13,39 +13,196 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"net/mail"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // Reader reads an mbox archive.
 type Reader struct {
-	r  *bufio.Reader
-	mr *messageReader
+	r      *bufio.Reader
+	cr     *countingReader
+	mr     *messageReader
+	index  int
+	offset int64
+	sep    []byte
+	opts   ReaderOptions
+}
+
+// ReaderOptions configures a Reader.
+type ReaderOptions struct {
+	// SkipExpunged skips the messages which X-Mozilla-Status headers mark
+	// expunged. Thunderbird keeps deleted messages in the mbox until the
+	// folder is compacted.
+	SkipExpunged bool
+
+	// SkipIMAPDeleted skips the messages which X-Mozilla-Status2 headers
+	// mark deleted on an IMAP server but not yet expunged from it.
+	SkipIMAPDeleted bool
 }
 
 type messageReader struct {
 	r              *bufio.Reader
+	cr             *countingReader
 	next           bytes.Buffer
 	atEOF          bool
 	atSeparator    bool
 	atMiddleOfLine bool
+	separatorAt    int64
+	separator      []byte
+}
+
+// countingReader counts the bytes read from the underlying reader, so that
+// message offsets can be reported relative to the start of the mbox data.
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
 var (
-	ErrInvalidFormat = errors.New("invalid mbox format")
-	reHeader         = regexp.MustCompile(`(?m)^[a-zA-Z0-9]{1,}(([-][a-zA-Z0-9]{1,})?)*\s*:`)
+	ErrInvalidFormat    = errors.New("invalid mbox format")
+	ErrInvalidSeparator = errors.New("invalid separator line")
+	reHeader            = regexp.MustCompile(`(?m)^[a-zA-Z0-9]{1,}(([-][a-zA-Z0-9]{1,})?)*\s*:`)
 )
 
+// separatorLayouts are the date formats found in "From " separator lines.
+var separatorLayouts = []string{
+	"Mon Jan _2 15:04:05 2006",
+	"Mon Jan _2 15:04:05 -0700 2006",
+	"Mon Jan _2 15:04:05 MST 2006",
+	"Mon Jan _2 15:04:05 2006 -0700",
+	"Mon Jan _2 15:04:05 2006 MST",
+	"Mon Jan _2 15:04 2006",
+	"Mon, _2 Jan 2006 15:04:05 -0700",
+}
+
 // NewReader returns a new Reader to read messages from mbox file format data
 // provided by io.Reader r.
 func NewReader(r io.Reader) *Reader {
-	return &Reader{r: bufio.NewReader(r)}
+	cr := &countingReader{r: r}
+
+	return &Reader{r: bufio.NewReader(cr), cr: cr, index: -1}
+}
+
+// NewReaderOptions is like NewReader, with options. A nil opts is the same as
+// NewReader.
+func NewReaderOptions(r io.Reader, opts *ReaderOptions) *Reader {
+	mr := NewReader(r)
+	if opts != nil {
+		mr.opts = *opts
+	}
+
+	return mr
+}
+
+// Index returns the zero-based index of the message most recently returned by
+// NextMessage, or -1 if NextMessage has not returned a message yet. Skipped
+// messages are counted, so that the index is the position of the message in
+// the mbox.
+func (r *Reader) Index() int {
+	return r.index
+}
+
+// Offset returns the byte offset of the "From " separator line of the message
+// most recently returned by NextMessage, relative to the start of the data
+// the Reader was created with.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Separator returns the "From " separator line of the message most recently
+// returned by NextMessage, without its line ending.
+func (r *Reader) Separator() string {
+	return string(r.sep)
+}
+
+// ParseSeparator returns the envelope sender and the delivery date recorded in
+// a "From " separator line.
+func ParseSeparator(line string) (string, time.Time, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "From" {
+		return "", time.Time{}, ErrInvalidSeparator
+	}
+
+	for _, start := range []int{2, 1} {
+		if start >= len(fields) {
+			continue
+		}
+
+		date := strings.Join(fields[start:], " ")
+		for _, layout := range separatorLayouts {
+			if t, err := time.Parse(layout, date); err == nil {
+				if start == 1 {
+					return "", t, nil
+				}
+				return fields[1], t, nil
+			}
+		}
+	}
+
+	return "", time.Time{}, ErrInvalidSeparator
 }
 
 // NextMessage returns the next message text (containing both the header and the
 // body). It will return io.EOF if there are no messages left.
 func (r *Reader) NextMessage() (io.Reader, error) {
+	for {
+		msg, err := r.nextMessage()
+		if err != nil || !(r.opts.SkipExpunged || r.opts.SkipIMAPDeleted) {
+			return msg, err
+		}
+
+		header, rest, err := readHeader(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		h, err := mail.ReadMessage(bytes.NewReader(append(header, "\r\n"...)))
+		if err != nil {
+			return io.MultiReader(bytes.NewReader(header), rest), nil
+		}
+
+		f, _ := ParseMozillaStatus(h.Header.Get("X-Mozilla-Status"), h.Header.Get("X-Mozilla-Status2"))
+		if !(r.opts.SkipExpunged && f.Has(MozillaExpunged)) && !(r.opts.SkipIMAPDeleted && f.Has(MozillaIMAPDeleted)) {
+			return io.MultiReader(bytes.NewReader(header), rest), nil
+		}
+	}
+}
+
+// readHeader reads the header lines of a message returned by nextMessage,
+// including the blank line ending them. It returns them and the rest of the
+// message.
+func readHeader(msg io.Reader) ([]byte, io.Reader, error) {
+	br := bufio.NewReader(msg)
+
+	var header []byte
+	midLine := false
+	for {
+		line, err := br.ReadSlice('\n')
+		header = append(header, line...)
+		if err == io.EOF {
+			break
+		} else if err == bufio.ErrBufferFull {
+			midLine = true
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if !midLine && len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+		midLine = false
+	}
+
+	return header, br, nil
+}
+
+func (r *Reader) nextMessage() (io.Reader, error) {
 	if r.mr == nil {
 		for {
+			off := position(r.cr, r.r)
 			b, isPrefix, err := r.r.ReadLine()
 			if err != nil {
 				return nil, err
@@ -63,7 +220,10 @@ func (r *Reader) NextMessage() (io.Reader, error) {
 				continue
 			}
 
+			b = cloneFromLine(b)
 			if isFromLine(r.r, b) {
+				r.offset = off
+				r.sep = b
 				break
 			} else {
 				return nil, ErrInvalidFormat
@@ -77,9 +237,13 @@ func (r *Reader) NextMessage() (io.Reader, error) {
 		if r.mr.atEOF {
 			return nil, io.EOF
 		}
+
+		r.offset = r.mr.separatorAt
+		r.sep = r.mr.separator
 	}
 
-	r.mr = &messageReader{r: r.r}
+	r.index++
+	r.mr = &messageReader{r: r.r, cr: r.cr}
 
 	return r.mr, nil
 }
@@ -90,6 +254,7 @@ func (mr *messageReader) Read(p []byte) (int, error) {
 	}
 
 	if mr.next.Len() == 0 {
+		off := position(mr.cr, mr.r)
 		b, isPrefix, err := mr.r.ReadLine()
 		if err != nil {
 			mr.atEOF = true
@@ -97,20 +262,27 @@ func (mr *messageReader) Read(p []byte) (int, error) {
 		}
 
 		if !mr.atMiddleOfLine {
+			b = cloneFromLine(b)
 			if isFromLine(mr.r, b) {
 				mr.atSeparator = true
+				mr.separatorAt = off
+				mr.separator = b
 				return 0, io.EOF
 			} else if len(b) == 0 {
 				// Check if the next line is separator. In such case the new
 				// line should not be written to not have double new line.
+				off = position(mr.cr, mr.r)
 				b, isPrefix, err = mr.r.ReadLine()
 				if err != nil {
 					mr.atEOF = true
 					return 0, err
 				}
 
+				b = cloneFromLine(b)
 				if isFromLine(mr.r, b) {
 					mr.atSeparator = true
+					mr.separatorAt = off
+					mr.separator = b
 					return 0, io.EOF
 				}
 
@@ -129,6 +301,29 @@ func (mr *messageReader) Read(p []byte) (int, error) {
 	return mr.next.Read(p)
 }
 
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+// position returns the offset of the next unread byte of br, which buffers
+// data read through cr.
+func position(cr *countingReader, br *bufio.Reader) int64 {
+	return cr.n - int64(br.Buffered())
+}
+
+// cloneFromLine copies lines starting with "From " out of the bufio.Reader
+// buffer, as the look-ahead done by isFromLine may overwrite it.
+func cloneFromLine(b []byte) []byte {
+	if bytes.HasPrefix(b, []byte("From ")) {
+		return bytes.Clone(b)
+	}
+
+	return b
+}
+
 func isFromLine(r *bufio.Reader, currentLine []byte) bool {
 	if !bytes.HasPrefix(currentLine, []byte("From ")) {
 		return false
@@ -909,3 +909,34 @@ func TestReadMBOXCLBadContentLength(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestReaderOffsets(t *testing.T) {
+	m := NewReader(strings.NewReader(mboxWithStartingLF))
+	if m.Index() != -1 {
+		t.Errorf("Expected index -1 before the first message, got %d", m.Index())
+	}
+
+	last := int64(-1)
+	for i := 0; ; i++ {
+		_, err := m.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		if m.Offset() <= last {
+			t.Errorf("%d - Offset %d is not after the previous offset %d", i, m.Offset(), last)
+		}
+		last = m.Offset()
+
+		if m.Index() != i {
+			t.Errorf("Expected index %d, got %d", i, m.Index())
+		}
+
+		off := m.Offset()
+		if !strings.HasPrefix(mboxWithStartingLF[off:], "From ") || (off > 0 && mboxWithStartingLF[off-1] != '\n') {
+			t.Errorf("%d - Offset %d does not point at a separator line", i, off)
+		}
+	}
+}
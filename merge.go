@@ -0,0 +1,229 @@
+package mbox
+
+import (
+	"bytes"
+	"container/heap"
+	"io"
+	"net/mail"
+	"time"
+)
+
+// MergeInput is an mbox read by Merge.
+type MergeInput struct {
+	// Name identifies the input in the summary returned by Merge.
+	Name string
+	// R provides the mbox data.
+	R io.Reader
+	// Variant is the variant of the input, which decides how its message
+	// bodies are unescaped.
+	Variant Variant
+}
+
+// MergeOptions configures Merge.
+type MergeOptions struct {
+	// Variant is the variant of the output.
+	Variant Variant
+
+	// ByDate interleaves the inputs by the Date header of their messages, or
+	// their separator date if they have none, instead of writing them one
+	// after the other. Each input is expected to be in date order already;
+	// messages with equal dates are taken from the earlier input first.
+	ByDate bool
+
+	// Dedup, if non-nil, drops every message whose key was already seen in
+	// the output, such as with MessageIDKey. Messages with an empty key are
+	// always written.
+	Dedup DedupKeyFunc
+
+	// MaxMemoryKeys and TempDir bound the memory used for deduplication, as
+	// in DedupOptions.
+	MaxMemoryKeys int
+	TempDir       string
+}
+
+// MergeSummary counts the messages Merge read from one input.
+type MergeSummary struct {
+	// Name is the name of the input.
+	Name string
+	// Messages is the number of messages written to the output.
+	Messages int
+	// Duplicates is the number of messages dropped as duplicates.
+	Duplicates int
+}
+
+// mergeMessage is a message read from a merge input, ready to be written.
+type mergeMessage struct {
+	input  int
+	sender string
+	sent   time.Time
+	date   time.Time
+	data   []byte
+}
+
+type mergeSource struct {
+	r   *Reader
+	v   Variant
+	cur *mergeMessage
+}
+
+// Merge writes the messages of all inputs to w as a single mbox of variant
+// opts.Variant, re-escaping the message bodies for it. A nil opts is the same
+// as the zero value. It returns the number of messages taken from each input,
+// in the order of inputs.
+func Merge(w io.Writer, inputs []MergeInput, opts *MergeOptions) ([]MergeSummary, error) {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	maxKeys := opts.MaxMemoryKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxMemoryKeys
+	}
+
+	keys := &keyTable{mem: map[keyDigest]int{}, max: maxKeys, dir: opts.TempDir}
+	defer keys.close()
+
+	summary := make([]MergeSummary, len(inputs))
+	srcs := make([]*mergeSource, len(inputs))
+	for i, in := range inputs {
+		summary[i].Name = in.Name
+		srcs[i] = &mergeSource{r: NewReader(in.R), v: in.Variant}
+	}
+
+	out := NewVariantWriter(w, opts.Variant)
+	write := func(m *mergeMessage) error {
+		if opts.Dedup != nil {
+			key, err := opts.Dedup(m.data)
+			if err != nil {
+				return err
+			}
+
+			if key != "" {
+				k := digestKey(key)
+				if _, found, err := keys.get(k); err != nil {
+					return err
+				} else if found {
+					summary[m.input].Duplicates++
+					return nil
+				}
+				if err := keys.put(k, m.input); err != nil {
+					return err
+				}
+			}
+		}
+
+		summary[m.input].Messages++
+
+		return out.WriteMessage(m.sender, m.sent, bytes.NewReader(m.data))
+	}
+
+	if !opts.ByDate {
+		for i, src := range srcs {
+			for {
+				m, err := src.next(i, opts.Variant)
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return summary, err
+				}
+
+				if err := write(m); err != nil {
+					return summary, err
+				}
+			}
+		}
+
+		return summary, out.Close()
+	}
+
+	h := &mergeHeap{}
+	for i, src := range srcs {
+		m, err := src.next(i, opts.Variant)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return summary, err
+		}
+
+		src.cur = m
+		h.srcs = append(h.srcs, src)
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		src := h.srcs[0]
+		if err := write(src.cur); err != nil {
+			return summary, err
+		}
+
+		m, err := src.next(src.cur.input, opts.Variant)
+		if err == io.EOF {
+			heap.Pop(h)
+			continue
+		} else if err != nil {
+			return summary, err
+		}
+
+		src.cur = m
+		heap.Fix(h, 0)
+	}
+
+	return summary, out.Close()
+}
+
+// next reads the next message of the source, input number i, unescaped and
+// with LF line endings.
+func (src *mergeSource) next(i int, out Variant) (*mergeMessage, error) {
+	msg, err := src.r.NextMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	header, body := splitMessage(normalizeNewlines(b))
+	if (src.v == Mboxcl || src.v == Mboxcl2) && out != Mboxcl && out != Mboxcl2 {
+		// The length no longer matches once the body is escaped again.
+		header = headerDel(header, "Content-Length")
+	}
+
+	m := &mergeMessage{input: i, data: joinMessage(header, src.v.unescape(body))}
+	m.sender, m.sent, _ = ParseSeparator(src.r.Separator())
+
+	m.date = m.sent
+	if h, err := mail.ReadMessage(bytes.NewReader(append(header, '\n'))); err == nil {
+		if t, err := h.Header.Date(); err == nil {
+			m.date = t
+		}
+	}
+
+	return m, nil
+}
+
+// mergeHeap orders merge sources by the date of their current message.
+type mergeHeap struct {
+	srcs []*mergeSource
+}
+
+func (h *mergeHeap) Len() int { return len(h.srcs) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.srcs[i].cur, h.srcs[j].cur
+	if !a.date.Equal(b.date) {
+		return a.date.Before(b.date)
+	}
+
+	return a.input < b.input
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.srcs[i], h.srcs[j] = h.srcs[j], h.srcs[i] }
+func (h *mergeHeap) Push(x any)    { h.srcs = append(h.srcs, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() any {
+	src := h.srcs[len(h.srcs)-1]
+	h.srcs = h.srcs[:len(h.srcs)-1]
+
+	return src
+}
@@ -0,0 +1,127 @@
+package mbox
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mboxMergeO = `From alice@example.com Wed Jan  1 00:00:01 2014
+From: alice@example.com
+Date: Wed, 01 Jan 2014 00:00:01 +0000
+Message-ID: <a@example.com>
+Subject: A
+
+>From the mboxo archive.
+
+From carol@example.com Sat Mar  1 00:00:01 2014
+From: carol@example.com
+Date: Sat, 01 Mar 2014 00:00:01 +0000
+Message-ID: <c@example.com>
+Subject: C
+
+Carol.
+
+`
+
+const mboxMergeCl = `From bob@example.com Sat Feb  1 00:00:01 2014
+From: bob@example.com
+Date: Sat, 01 Feb 2014 00:00:01 +0000
+Message-ID: <b@example.com>
+Content-Length: 44
+Subject: B
+
+>From the mboxcl archive.
+>>From quoted.
+
+From carol@example.com Sat Mar  1 00:00:01 2014
+From: carol@example.com
+Date: Sat, 01 Mar 2014 00:00:01 +0000
+Message-ID: <c@example.com>
+Subject: C
+
+Carol.
+
+`
+
+func TestMerge(t *testing.T) {
+	inputs := func() []MergeInput {
+		return []MergeInput{
+			{Name: "old", R: strings.NewReader(mboxMergeO), Variant: Mboxo},
+			{Name: "new", R: strings.NewReader(mboxMergeCl), Variant: Mboxcl},
+		}
+	}
+
+	tests := []struct {
+		opts     MergeOptions
+		subjects []string
+		summary  []MergeSummary
+	}{
+		{
+			MergeOptions{},
+			[]string{"A", "C", "B", "C"},
+			[]MergeSummary{{"old", 2, 0}, {"new", 2, 0}},
+		},
+		{
+			MergeOptions{ByDate: true},
+			[]string{"A", "B", "C", "C"},
+			[]MergeSummary{{"old", 2, 0}, {"new", 2, 0}},
+		},
+		{
+			MergeOptions{ByDate: true, Dedup: MessageIDKey},
+			[]string{"A", "B", "C"},
+			[]MergeSummary{{"old", 2, 0}, {"new", 1, 1}},
+		},
+		{
+			MergeOptions{Dedup: MessageIDKey, MaxMemoryKeys: 1, TempDir: t.TempDir()},
+			[]string{"A", "C", "B"},
+			[]MergeSummary{{"old", 2, 0}, {"new", 1, 1}},
+		},
+	}
+
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		summary, err := Merge(&buf, inputs(), &tt.opts)
+		if err != nil {
+			t.Fatalf("%d - Merge() = %v", i, err)
+		}
+
+		if !reflect.DeepEqual(summary, tt.summary) {
+			t.Errorf("%d - Expected summary %v, got %v", i, tt.summary, summary)
+		}
+
+		var subjects []string
+		for _, s := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(s, "Subject: ") {
+				subjects = append(subjects, strings.TrimPrefix(s, "Subject: "))
+			}
+		}
+		if !reflect.DeepEqual(subjects, tt.subjects) {
+			t.Errorf("%d - Expected subjects %v, got %v", i, tt.subjects, subjects)
+		}
+
+		// Bodies are re-escaped for mboxrd, and the stale length dropped.
+		out := buf.String()
+		if !strings.Contains(out, "\n>From the mboxo archive.\n") ||
+			!strings.Contains(out, "\n>From the mboxcl archive.\n>>>From quoted.\n") {
+			t.Errorf("%d - Unexpected escaping:\n%s", i, out)
+		}
+		if strings.Contains(out, "Content-Length") {
+			t.Errorf("%d - Content-Length kept:\n%s", i, out)
+		}
+	}
+}
+
+func TestMergeVariant(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := Merge(&buf, []MergeInput{{R: strings.NewReader(mboxMergeCl), Variant: Mboxcl}}, &MergeOptions{Variant: Mboxo})
+	if err != nil {
+		t.Fatalf("Merge() = %v", err)
+	}
+
+	// Mboxo escapes "From " lines only; the already quoted line is kept.
+	if !strings.Contains(buf.String(), "\n>From the mboxcl archive.\n>>From quoted.\n") {
+		t.Errorf("Unexpected escaping:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,432 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mhSequencesFile is the name of the file holding the sequences of an MH
+// folder.
+const mhSequencesFile = ".mh_sequences"
+
+var ErrNotMHFolder = errors.New("not an MH folder")
+
+// MHMessage describes a message file in an MH folder.
+type MHMessage struct {
+	// Path is the path of the message file.
+	Path string
+	// Number is the message number, which is also its file name.
+	Number int
+	// Sequences lists the sequences containing the message, such as
+	// "unseen" or "flagged".
+	Sequences []string
+	// ModTime is the modification time of the message file.
+	ModTime time.Time
+}
+
+// MHReader reads the messages of an MH folder in message number order.
+type MHReader struct {
+	msgs []*MHMessage
+	cur  *MHMessage
+	f    *os.File
+}
+
+// NewMHReader returns an MHReader for the MH folder at dir.
+func NewMHReader(dir string) (*MHReader, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotMHFolder
+	} else if err != nil {
+		return nil, err
+	}
+
+	seqs, err := readMHSequences(dir, mhNumbers(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &MHReader{}
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || n <= 0 || e.IsDir() {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		m := &MHMessage{Path: filepath.Join(dir, e.Name()), Number: n, ModTime: info.ModTime()}
+		for name, nums := range seqs {
+			if nums[n] {
+				m.Sequences = append(m.Sequences, name)
+			}
+		}
+		sort.Strings(m.Sequences)
+
+		r.msgs = append(r.msgs, m)
+	}
+
+	sort.Slice(r.msgs, func(i, j int) bool {
+		return r.msgs[i].Number < r.msgs[j].Number
+	})
+
+	return r, nil
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (r *MHReader) NextMessage() (io.Reader, error) {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+
+	if len(r.msgs) == 0 {
+		r.cur = nil
+		return nil, io.EOF
+	}
+
+	r.cur, r.msgs = r.msgs[0], r.msgs[1:]
+
+	f, err := os.Open(r.cur.Path)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+
+	return f, nil
+}
+
+// Message describes the message most recently returned by NextMessage.
+func (r *MHReader) Message() *MHMessage {
+	return r.cur
+}
+
+// Close closes the file of the current message.
+func (r *MHReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
+
+// MHWriter adds messages to an MH folder. Sequences are written to the
+// .mh_sequences file when the MHWriter is closed.
+type MHWriter struct {
+	dir  string
+	next int
+	seqs map[string]map[int]bool
+}
+
+// NewMHWriter returns an MHWriter for the MH folder at dir, creating it if
+// needed. New messages are numbered after the highest existing one.
+func NewMHWriter(dir string) (*MHWriter, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &MHWriter{dir: dir, next: 1}
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil && n >= w.next {
+			w.next = n + 1
+		}
+	}
+
+	if w.seqs, err = readMHSequences(dir, mhNumbers(entries)); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// WriteMessage adds the message read from r to the folder as part of the
+// given sequences. A non-zero date is used as the modification time of the
+// file. It returns the number of the new message.
+func (w *MHWriter) WriteMessage(r io.Reader, sequences []string, date time.Time) (int, error) {
+	for {
+		path := filepath.Join(w.dir, strconv.Itoa(w.next))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			w.next++
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		n := w.next
+		w.next++
+
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && !date.IsZero() {
+			err = os.Chtimes(path, date, date)
+		}
+		if err != nil {
+			os.Remove(path)
+			return 0, err
+		}
+
+		for _, s := range sequences {
+			if w.seqs[s] == nil {
+				w.seqs[s] = map[int]bool{}
+			}
+			w.seqs[s][n] = true
+		}
+
+		return n, nil
+	}
+}
+
+// Close writes the .mh_sequences file of the folder.
+func (w *MHWriter) Close() error {
+	return writeMHSequences(w.dir, w.seqs)
+}
+
+// mhNumbers returns the numbers of the messages among the entries of an MH
+// folder, in order.
+func mhNumbers(entries []os.DirEntry) []int {
+	var nums []int
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil && n > 0 && !e.IsDir() {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+
+	return nums
+}
+
+// readMHSequences parses the .mh_sequences file of a folder holding the
+// messages numbered nums, in order. Sequences are written as "name: 1-3 5",
+// possibly continued on indented lines. Numbers without a message are
+// dropped, so that a range such as "1-2000000000" costs no more than the
+// messages it covers.
+func readMHSequences(dir string, nums []int) (map[string]map[int]bool, error) {
+	seqs := map[string]map[int]bool{}
+
+	f, err := os.Open(filepath.Join(dir, mhSequencesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return seqs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var name string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			var ok bool
+			if name, line, ok = strings.Cut(line, ":"); !ok {
+				name = ""
+				continue
+			}
+			name = strings.TrimSpace(name)
+		}
+		if name == "" {
+			continue
+		}
+
+		if seqs[name] == nil {
+			seqs[name] = map[int]bool{}
+		}
+
+		for _, field := range strings.Fields(line) {
+			lo, hi, isRange := strings.Cut(field, "-")
+			a, err := strconv.Atoi(lo)
+			if err != nil {
+				continue
+			}
+			b := a
+			if isRange {
+				if b, err = strconv.Atoi(hi); err != nil {
+					continue
+				}
+			}
+			for i := sort.SearchInts(nums, a); i < len(nums) && nums[i] <= b; i++ {
+				seqs[name][nums[i]] = true
+			}
+		}
+	}
+
+	return seqs, s.Err()
+}
+
+// writeMHSequences replaces the .mh_sequences file of a folder.
+func writeMHSequences(dir string, seqs map[string]map[int]bool) error {
+	names := make([]string, 0, len(seqs))
+	for name, nums := range seqs {
+		if len(nums) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		nums := make([]int, 0, len(seqs[name]))
+		for n := range seqs[name] {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+
+		b.WriteString(name + ":")
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+
+			b.WriteString(" " + strconv.Itoa(nums[i]))
+			if j > i {
+				b.WriteString("-" + strconv.Itoa(nums[j]))
+			}
+			i = j + 1
+		}
+		b.WriteString("\n")
+	}
+
+	tmp, err := os.CreateTemp(dir, mhSequencesFile+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(b.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, mhSequencesFile))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// MboxToMH converts the messages read from r, an mbox of variant v, into the
+// MH folder at dir. Status and X-Status headers become sequences and the
+// separator date the file modification time. Messages with a malformed header
+// are converted as they are. It returns the number of converted messages.
+func MboxToMH(r *Reader, dir string, v Variant) (int, error) {
+	w, err := NewMHWriter(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			w.Close()
+			return n, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			w.Close()
+			return n, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		h, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+		if err != nil {
+			// The header is malformed: convert the message as it is.
+			h = &mail.Message{Header: mail.Header{}}
+		}
+
+		seqs := HeaderFlags(h.Header).mhSequences()
+		header = headerDel(header, "Status", "X-Status")
+
+		sender, date, _ := ParseSeparator(r.Separator())
+		if sender != "" && h.Header.Get("Return-Path") == "" {
+			header = append([]byte("Return-Path: <"+sender+">\n"), header...)
+		}
+		if date.IsZero() {
+			date, _ = h.Header.Date()
+		}
+
+		if _, err := w.WriteMessage(bytes.NewReader(joinMessage(header, v.unescape(body))), seqs, date); err != nil {
+			w.Close()
+			return n, err
+		}
+		n++
+	}
+
+	return n, w.Close()
+}
+
+// MHToMbox writes the messages of the MH folder at dir to w. Sequences become
+// Status and X-Status headers, the Return-Path or From address provides the
+// envelope sender and the file modification time the separator date. It
+// returns the number of converted messages.
+func MHToMbox(dir string, w *Writer) (int, error) {
+	r, err := NewMHReader(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	n := 0
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return n, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		h, err := mail.ReadMessage(bytes.NewReader(append(header, '\n')))
+		if err != nil {
+			// The header is malformed: convert the message as it is.
+			h = &mail.Message{Header: mail.Header{}}
+		}
+
+		m := r.Message()
+		status, xstatus := flagsFromMHSequences(m.Sequences).Status()
+		header = headerDel(header, "Status", "X-Status")
+		header = headerAdd(header, "Status", status)
+		header = headerAdd(header, "X-Status", xstatus)
+
+		if err := w.WriteMessage(envelopeSender(h.Header), m.ModTime, bytes.NewReader(joinMessage(header, body))); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,142 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMHRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "inbox")
+
+	n, err := MboxToMH(NewReader(strings.NewReader(mboxWithStatus)), dir, Mboxrd)
+	if err != nil || n != 3 {
+		t.Fatalf("MboxToMH() = %d, %v", n, err)
+	}
+
+	seqs, err := os.ReadFile(filepath.Join(dir, ".mh_sequences"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "flagged: 2\nreplied: 2\nunseen: 1 3\n"; string(seqs) != want {
+		t.Errorf("Expected sequences:\n%s\ngot\n%s", want, seqs)
+	}
+
+	// Appending continues the numbering and extends the sequences.
+	w, err := NewMHWriter(dir)
+	if err != nil {
+		t.Fatalf("NewMHWriter() = %v", err)
+	}
+	if n, err := w.WriteMessage(strings.NewReader("From: dave@example.com\nSubject: New\n\nHi.\n"), []string{"unseen"}, time.Date(2015, 1, 1, 0, 0, 1, 0, time.UTC)); err != nil || n != 4 {
+		t.Fatalf("WriteMessage() = %d, %v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	r, err := NewMHReader(dir)
+	if err != nil {
+		t.Fatalf("NewMHReader() = %v", err)
+	}
+
+	var got []string
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+		got = append(got, m.Header.Get("Subject")+":"+strings.Join(r.Message().Sequences, ","))
+	}
+	r.Close()
+
+	want := "Unread:unseen|Read and answered:flagged,replied|Old:unseen|New:unseen"
+	if strings.Join(got, "|") != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, strings.Join(got, "|"))
+	}
+
+	var b bytes.Buffer
+	mw := NewWriter(&b)
+	if n, err := MHToMbox(dir, mw); err != nil || n != 4 {
+		t.Fatalf("MHToMbox() = %d, %v", n, err)
+	}
+	mw.Close()
+
+	for _, s := range []string{
+		"From alice@example.com Thu Jan  1 00:00:01 2015\nReturn-Path: <alice@example.com>\nFrom: alice@example.com\nSubject: Unread\nStatus: O\n\n>From the top.\n",
+		"Subject: Read and answered\nStatus: RO\nX-Status: AF\n",
+		"From dave@example.com Thu Jan  1 00:00:01 2015\n",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("Expected output to contain %q:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestMHMalformedHeader(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "inbox")
+	data := "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: Broken\nnot a header\n\nBody.\n\n" + mboxWithStatus
+
+	if n, err := MboxToMH(NewReader(strings.NewReader(data)), dir, Mboxrd); err != nil || n != 4 {
+		t.Fatalf("MboxToMH() = %d, %v", n, err)
+	}
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if n, err := MHToMbox(dir, w); err != nil || n != 4 {
+		t.Fatalf("MHToMbox() = %d, %v", n, err)
+	}
+	w.Close()
+
+	if want := "Return-Path: <a@example.com>\nFrom: a@example.com\nSubject: Broken\nnot a header\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Expected output to contain %q:\n%s", want, b.String())
+	}
+}
+
+func TestMHSequencesParsing(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".mh_sequences"), []byte("unseen: 1-3 7\n 9\ncur: 2\nflagged: 2-2000000000 12\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	seqs, err := readMHSequences(dir, []int{1, 2, 3, 7, 9, 10})
+	if err != nil {
+		t.Fatalf("readMHSequences() = %v", err)
+	}
+
+	if len(seqs["unseen"]) != 5 || !seqs["unseen"][9] || !seqs["cur"][2] {
+		t.Errorf("Unexpected sequences: %v", seqs)
+	}
+
+	// Ranges only cover existing messages.
+	if len(seqs["flagged"]) != 5 || seqs["flagged"][1] || !seqs["flagged"][10] || seqs["flagged"][12] {
+		t.Errorf("Unexpected sequences: %v", seqs)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "2"), []byte("Subject: x\n\ny\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewMHReader(dir)
+	if err != nil {
+		t.Fatalf("NewMHReader() = %v", err)
+	}
+	if _, err := r.NextMessage(); err != nil || strings.Join(r.Message().Sequences, ",") != "cur,flagged,unseen" {
+		t.Errorf("Unexpected message: %+v, %v", r.Message(), err)
+	}
+	r.Close()
+
+	if _, err := NewMHReader(filepath.Join(dir, "missing")); err != ErrNotMHFolder {
+		t.Errorf("Expected ErrNotMHFolder, got %v", err)
+	}
+}
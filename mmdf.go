@@ -0,0 +1,124 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// mmdfDelimiter is the line surrounding every message of an MMDF mailbox.
+var mmdfDelimiter = []byte("\x01\x01\x01\x01")
+
+// MMDFReader reads an MMDF mailbox, where each message is enclosed by lines of
+// four Ctrl-A characters. Messages are returned with CRLF line endings, like
+// those of Reader.
+type MMDFReader struct {
+	r      *bufio.Reader
+	cr     *countingReader
+	mr     *mmdfMessageReader
+	index  int
+	offset int64
+}
+
+type mmdfMessageReader struct {
+	r              *bufio.Reader
+	next           bytes.Buffer
+	atEOF          bool
+	atEnd          bool
+	atMiddleOfLine bool
+}
+
+// NewMMDFReader returns a new MMDFReader to read messages from MMDF data
+// provided by io.Reader r.
+func NewMMDFReader(r io.Reader) *MMDFReader {
+	cr := &countingReader{r: r}
+
+	return &MMDFReader{r: bufio.NewReader(cr), cr: cr, index: -1}
+}
+
+// Index returns the zero-based index of the message most recently returned by
+// NextMessage, or -1 if NextMessage has not returned a message yet.
+func (r *MMDFReader) Index() int {
+	return r.index
+}
+
+// Offset returns the byte offset of the opening delimiter line of the message
+// most recently returned by NextMessage.
+func (r *MMDFReader) Offset() int64 {
+	return r.offset
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (r *MMDFReader) NextMessage() (io.Reader, error) {
+	if r.mr != nil {
+		if _, err := io.Copy(io.Discard, r.mr); err != nil {
+			return nil, err
+		}
+
+		if r.mr.atEOF {
+			return nil, io.EOF
+		}
+	}
+
+	for {
+		off := position(r.cr, r.r)
+		b, isPrefix, err := r.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		// Discard the rest of the line.
+		for isPrefix {
+			_, isPrefix, err = r.r.ReadLine()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+
+		if !bytes.Equal(bytes.TrimRight(b, "\r"), mmdfDelimiter) {
+			return nil, ErrInvalidFormat
+		}
+
+		r.offset = off
+		break
+	}
+
+	r.index++
+	r.mr = &mmdfMessageReader{r: r.r}
+
+	return r.mr, nil
+}
+
+func (mr *mmdfMessageReader) Read(p []byte) (int, error) {
+	if mr.atEOF || mr.atEnd {
+		return 0, io.EOF
+	}
+
+	if mr.next.Len() == 0 {
+		b, isPrefix, err := mr.r.ReadLine()
+		if err != nil {
+			// A missing closing delimiter ends the message at EOF.
+			mr.atEOF = true
+			return 0, err
+		}
+
+		if !mr.atMiddleOfLine && bytes.Equal(bytes.TrimRight(b, "\r"), mmdfDelimiter) {
+			mr.atEnd = true
+			return 0, io.EOF
+		}
+
+		mr.next.Write(b)
+		if !isPrefix {
+			mr.next.Write([]byte("\r\n"))
+		}
+
+		mr.atMiddleOfLine = isPrefix
+	}
+
+	return mr.next.Read(p)
+}
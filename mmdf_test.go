@@ -0,0 +1,102 @@
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+const mmdfWithTwoMessages = "\x01\x01\x01\x01\n" +
+	"From: herp.derp@example.com (Herp Derp)\n" +
+	"Subject: Test\n" +
+	"\n" +
+	"From here on no escaping is needed.\n" +
+	"\x01\x01\x01\x01\n" +
+	"\x01\x01\x01\x01\n" +
+	"From: derp.herp@example.com (Derp Herp)\n" +
+	"Subject: Another test\n" +
+	"\n" +
+	"Bye.\n" +
+	"\x01\x01\x01\x01\n"
+
+func TestMMDFReader(t *testing.T) {
+	r := NewMMDFReader(strings.NewReader(mmdfWithTwoMessages))
+
+	want := []struct {
+		subject string
+		body    string
+		offset  int64
+	}{
+		{"Test", "From here on no escaping is needed.\r\n", 0},
+		{"Another test", "Bye.\r\n", int64(strings.Index(mmdfWithTwoMessages, "\x01\x01\x01\x01\nFrom: derp"))},
+	}
+
+	for i, w := range want {
+		msg, err := r.NextMessage()
+		if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+
+		body, err := io.ReadAll(m.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Header.Get("Subject") != w.subject || string(body) != w.body || r.Offset() != w.offset || r.Index() != i {
+			t.Errorf("%d - Unexpected message %q / %q at %d", i, m.Header.Get("Subject"), body, r.Offset())
+		}
+	}
+
+	if _, err := r.NextMessage(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestMMDFReaderInvalid(t *testing.T) {
+	r := NewMMDFReader(strings.NewReader(mboxWithOneMessage))
+	if _, err := r.NextMessage(); err != ErrInvalidFormat {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+}
+
+func TestNewMailboxReader(t *testing.T) {
+	tests := []struct {
+		data   string
+		format Format
+	}{
+		{mboxWithStartingLF, FormatMbox},
+		{"\n" + mmdfWithTwoMessages, FormatMMDF},
+		{babylWithTwoMessages, FormatBabyl},
+	}
+
+	for _, tt := range tests {
+		r, f, err := NewMailboxReader(strings.NewReader(tt.data))
+		if err != nil || f != tt.format {
+			t.Fatalf("NewMailboxReader() = %v, %v", f, err)
+		}
+
+		n := 0
+		for {
+			if _, err := r.NextMessage(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%v - Unexpected error after NextMessage(): %v", f, err)
+			}
+			n++
+		}
+
+		if n < 2 {
+			t.Errorf("%v - Expected several messages, got %d", f, n)
+		}
+	}
+
+	if _, _, err := NewMailboxReader(strings.NewReader("Subject: not a mailbox\n")); err != ErrInvalidFormat {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package mbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MozillaFlags holds the message flags Thunderbird and other Mozilla mail
+// clients record in the X-Mozilla-Status (low 16 bits) and X-Mozilla-Status2
+// (high 16 bits) headers.
+type MozillaFlags uint32
+
+const (
+	MozillaRead         MozillaFlags = 0x00000001
+	MozillaReplied      MozillaFlags = 0x00000002
+	MozillaMarked       MozillaFlags = 0x00000004
+	MozillaExpunged     MozillaFlags = 0x00000008
+	MozillaHasRe        MozillaFlags = 0x00000010
+	MozillaElided       MozillaFlags = 0x00000020
+	MozillaFeed         MozillaFlags = 0x00000040
+	MozillaOffline      MozillaFlags = 0x00000080
+	MozillaWatched      MozillaFlags = 0x00000100
+	MozillaSenderAuthed MozillaFlags = 0x00000200
+	MozillaPartial      MozillaFlags = 0x00000400
+	MozillaQueued       MozillaFlags = 0x00000800
+	MozillaForwarded    MozillaFlags = 0x00001000
+	MozillaNew          MozillaFlags = 0x00010000
+	MozillaIgnored      MozillaFlags = 0x00040000
+	MozillaIMAPDeleted  MozillaFlags = 0x00200000
+	MozillaMDNNeeded    MozillaFlags = 0x00400000
+	MozillaMDNSent      MozillaFlags = 0x00800000
+	MozillaTemplate     MozillaFlags = 0x01000000
+	MozillaAttachment   MozillaFlags = 0x10000000
+
+	// mozillaPriorityMask holds the message priority, from 0 (not set) to
+	// 6 (highest).
+	mozillaPriorityMask MozillaFlags = 0x0000e000
+	// mozillaLabelMask holds the legacy label number, from 0 to 7.
+	mozillaLabelMask MozillaFlags = 0x0e000000
+)
+
+var mozillaFlagNames = []struct {
+	flag MozillaFlags
+	name string
+}{
+	{MozillaRead, "read"},
+	{MozillaReplied, "replied"},
+	{MozillaMarked, "marked"},
+	{MozillaExpunged, "expunged"},
+	{MozillaHasRe, "hasre"},
+	{MozillaElided, "elided"},
+	{MozillaFeed, "feed"},
+	{MozillaOffline, "offline"},
+	{MozillaWatched, "watched"},
+	{MozillaSenderAuthed, "senderauthed"},
+	{MozillaPartial, "partial"},
+	{MozillaQueued, "queued"},
+	{MozillaForwarded, "forwarded"},
+	{MozillaNew, "new"},
+	{MozillaIgnored, "ignored"},
+	{MozillaIMAPDeleted, "imapdeleted"},
+	{MozillaMDNNeeded, "mdnneeded"},
+	{MozillaMDNSent, "mdnsent"},
+	{MozillaTemplate, "template"},
+	{MozillaAttachment, "attachment"},
+}
+
+// ParseMozillaStatus decodes the values of the X-Mozilla-Status and
+// X-Mozilla-Status2 headers, either of which may be empty. It returns
+// ErrInvalidFormat if a value is not a hexadecimal number.
+func ParseMozillaStatus(status, status2 string) (MozillaFlags, error) {
+	var f MozillaFlags
+
+	if status = strings.TrimSpace(status); status != "" {
+		v, err := strconv.ParseUint(status, 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("%w: X-Mozilla-Status %q", ErrInvalidFormat, status)
+		}
+		f |= MozillaFlags(v)
+	}
+
+	if status2 = strings.TrimSpace(status2); status2 != "" {
+		v, err := strconv.ParseUint(status2, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: X-Mozilla-Status2 %q", ErrInvalidFormat, status2)
+		}
+		f |= MozillaFlags(v) &^ 0xffff
+	}
+
+	return f, nil
+}
+
+// Has reports whether all of flags are set.
+func (f MozillaFlags) Has(flags MozillaFlags) bool {
+	return f&flags == flags
+}
+
+// Deleted reports whether the message is expunged from a local folder or
+// marked deleted on an IMAP server.
+func (f MozillaFlags) Deleted() bool {
+	return f&(MozillaExpunged|MozillaIMAPDeleted) != 0
+}
+
+// Priority returns the priority of the message, from 0 (not set) through
+// 1 (none) and 2 (lowest) to 6 (highest).
+func (f MozillaFlags) Priority() int {
+	return int(f&mozillaPriorityMask) >> 13
+}
+
+// Label returns the legacy label number of the message, or 0.
+func (f MozillaFlags) Label() int {
+	return int(f&mozillaLabelMask) >> 25
+}
+
+// Status returns the values of the X-Mozilla-Status and X-Mozilla-Status2
+// headers encoding f.
+func (f MozillaFlags) Status() (string, string) {
+	return fmt.Sprintf("%04x", uint32(f)&0xffff), fmt.Sprintf("%08x", uint32(f)&^0xffff)
+}
+
+// String returns the names of the flags set in f, separated by commas.
+func (f MozillaFlags) String() string {
+	var names []string
+	for _, n := range mozillaFlagNames {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+		}
+	}
+	if p := f.Priority(); p != 0 {
+		names = append(names, "priority="+strconv.Itoa(p))
+	}
+	if l := f.Label(); l != 0 {
+		names = append(names, "label="+strconv.Itoa(l))
+	}
+
+	return strings.Join(names, ",")
+}
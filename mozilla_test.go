@@ -0,0 +1,123 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const mboxMozilla = `From - Thu Jan  1 00:00:01 2015
+X-Mozilla-Status: 0001
+X-Mozilla-Status2: 00000000
+From: alice@example.com
+Subject: Read
+
+Kept.
+
+From - Thu Jan  1 00:00:02 2015
+X-Mozilla-Status: 0009
+X-Mozilla-Status2: 00000000
+From: bob@example.com
+Subject: Expunged
+
+Deleted locally.
+
+From - Thu Jan  1 00:00:03 2015
+X-Mozilla-Status: 1006
+X-Mozilla-Status2: 10000000
+From: carol@example.com
+Subject: Replied and forwarded
+
+Kept too.
+
+From - Thu Jan  1 00:00:04 2015
+X-Mozilla-Status: 0001
+X-Mozilla-Status2: 00200000
+From: dave@example.com
+Subject: IMAP deleted
+
+Deleted on the server.
+`
+
+func TestParseMozillaStatus(t *testing.T) {
+	tests := []struct {
+		status, status2 string
+		want            MozillaFlags
+		str             string
+	}{
+		{"0001", "00000000", MozillaRead, "read"},
+		{"0009", "", MozillaRead | MozillaExpunged, "read,expunged"},
+		{"1006", "10010000", MozillaReplied | MozillaMarked | MozillaForwarded | MozillaNew | MozillaAttachment, "replied,marked,forwarded,new,attachment"},
+		{"8001", "04000000", MozillaRead | 0x8000 | 0x04000000, "read,priority=4,label=2"},
+		{"", "", 0, ""},
+		// The low bits of X-Mozilla-Status2 are not used.
+		{"0000", "0000ffff", 0, ""},
+	}
+
+	for _, tt := range tests {
+		f, err := ParseMozillaStatus(tt.status, tt.status2)
+		if err != nil {
+			t.Fatalf("ParseMozillaStatus(%q, %q) = %v", tt.status, tt.status2, err)
+		}
+		if f != tt.want || f.String() != tt.str {
+			t.Errorf("ParseMozillaStatus(%q, %q) = %#x (%s), want %#x (%s)", tt.status, tt.status2, uint32(f), f, uint32(tt.want), tt.str)
+		}
+	}
+
+	if _, err := ParseMozillaStatus("zz", ""); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+
+	f := MozillaRead | MozillaExpunged | MozillaAttachment
+	if s, s2 := f.Status(); s != "0009" || s2 != "10000000" {
+		t.Errorf("Status() = %q, %q", s, s2)
+	}
+	if !f.Deleted() || MozillaIMAPDeleted.Deleted() != true || MozillaRead.Deleted() {
+		t.Errorf("Unexpected Deleted() results")
+	}
+}
+
+func TestReaderSkipExpunged(t *testing.T) {
+	tests := []struct {
+		opts ReaderOptions
+		want string
+	}{
+		{ReaderOptions{}, "Read:0:Kept.|Expunged:1:Deleted locally.|Replied and forwarded:2:Kept too.|IMAP deleted:3:Deleted on the server."},
+		{ReaderOptions{SkipExpunged: true}, "Read:0:Kept.|Replied and forwarded:2:Kept too.|IMAP deleted:3:Deleted on the server."},
+		{ReaderOptions{SkipIMAPDeleted: true}, "Read:0:Kept.|Expunged:1:Deleted locally.|Replied and forwarded:2:Kept too."},
+		{ReaderOptions{SkipExpunged: true, SkipIMAPDeleted: true}, "Read:0:Kept.|Replied and forwarded:2:Kept too."},
+	}
+
+	for _, tt := range tests {
+		r := NewReaderOptions(strings.NewReader(mboxMozilla), &tt.opts)
+
+		var got []string
+		for {
+			msg, err := r.NextMessage()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Unexpected error after NextMessage(): %v", err)
+			}
+
+			m, err := mail.ReadMessage(msg)
+			if err != nil {
+				t.Fatalf("mail.ReadMessage() = %v", err)
+			}
+
+			body, err := io.ReadAll(m.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, m.Header.Get("Subject")+":"+strconv.Itoa(r.Index())+":"+strings.TrimSpace(string(body)))
+		}
+
+		if strings.Join(got, "|") != tt.want {
+			t.Errorf("%+v - Expected:\n%s\ngot\n%s", tt.opts, tt.want, strings.Join(got, "|"))
+		}
+	}
+}
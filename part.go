@@ -0,0 +1,188 @@
+package mbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+)
+
+// maxPartDepth limits how deeply nested multipart entities are followed.
+const maxPartDepth = 32
+
+var errPartTooDeep = errors.New("mime structure nested too deeply")
+
+// entity is a single MIME entity of a message: either the top-level message
+// itself or one of its (possibly nested) body parts.
+type entity struct {
+	header      textproto.MIMEHeader
+	mediaType   string
+	params      map[string]string
+	disposition string
+	filename    string
+	body        io.Reader
+}
+
+func newEntity(h textproto.MIMEHeader, body io.Reader) *entity {
+	e := &entity{header: h, body: body, mediaType: "text/plain", params: map[string]string{}}
+
+	if ct := h.Get("Content-Type"); ct != "" {
+		mt, params, err := mime.ParseMediaType(ct)
+		if err == nil || errors.Is(err, mime.ErrInvalidMediaParameter) {
+			e.mediaType = mt
+			e.params = params
+		} else if i := strings.IndexByte(ct, ';'); i > 0 {
+			e.mediaType = strings.ToLower(strings.TrimSpace(ct[:i]))
+		} else {
+			e.mediaType = strings.ToLower(strings.TrimSpace(ct))
+		}
+	}
+
+	if cd := h.Get("Content-Disposition"); cd != "" {
+		d, params, err := mime.ParseMediaType(cd)
+		if err == nil || errors.Is(err, mime.ErrInvalidMediaParameter) {
+			e.disposition = d
+			e.filename = params["filename"]
+		}
+	}
+	if e.filename == "" {
+		e.filename = e.params["name"]
+	}
+
+	return e
+}
+
+// isMultipart reports whether the entity contains nested body parts.
+func (e *entity) isMultipart() bool {
+	return strings.HasPrefix(e.mediaType, "multipart/") && e.params["boundary"] != ""
+}
+
+// isAttachment reports whether the entity is meant to be saved rather than
+// displayed inline.
+func (e *entity) isAttachment() bool {
+	switch e.disposition {
+	case "attachment":
+		return true
+	case "inline":
+		return false
+	}
+
+	return e.filename != ""
+}
+
+// decoded returns the entity body with its Content-Transfer-Encoding removed.
+func (e *entity) decoded() io.Reader {
+	switch strings.ToLower(strings.TrimSpace(e.header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: e.body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(e.body)
+	}
+
+	return e.body
+}
+
+// text returns the decoded entity body converted to UTF-8.
+func (e *entity) text(charsetReader func(string, io.Reader) (io.Reader, error)) (string, error) {
+	b, err := io.ReadAll(e.decoded())
+	if err != nil {
+		return "", err
+	}
+
+	return decodeCharset(e.params["charset"], b, charsetReader)
+}
+
+// parts calls fn for every direct child of a multipart entity.
+func (e *entity) parts(fn func(*entity) error) error {
+	mr := multipart.NewReader(e.body, e.params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := fn(newEntity(p.Header, p)); err != nil {
+			return err
+		}
+	}
+}
+
+// walk calls fn for every leaf entity below e, in document order. Nested
+// messages (message/rfc822) are reported as leaves.
+func (e *entity) walk(depth int, fn func(*entity) error) error {
+	if !e.isMultipart() {
+		return fn(e)
+	}
+
+	if depth >= maxPartDepth {
+		return errPartTooDeep
+	}
+
+	return e.parts(func(p *entity) error {
+		return p.walk(depth+1, fn)
+	})
+}
+
+// base64Cleaner drops the spaces and tabs some mailers leave on base64 encoded
+// lines, which the standard decoder would otherwise reject.
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	for {
+		n, err := c.r.Read(p)
+		j := 0
+		for _, b := range p[:n] {
+			if b == ' ' || b == '\t' {
+				continue
+			}
+			p[j] = b
+			j++
+		}
+
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
+
+// decodeCharset converts b from charset to UTF-8. UTF-8, US-ASCII and
+// ISO-8859-1 are handled natively, anything else is passed to charsetReader.
+// Without a charsetReader unknown charsets are returned unchanged.
+func decodeCharset(charset string, b []byte, charsetReader func(string, io.Reader) (io.Reader, error)) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return string(b), nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		var sb strings.Builder
+		for _, c := range b {
+			sb.WriteRune(rune(c))
+		}
+
+		return sb.String(), nil
+	}
+
+	if charsetReader == nil {
+		return string(b), nil
+	}
+
+	r, err := charsetReader(strings.ToLower(charset), bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
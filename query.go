@@ -0,0 +1,591 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+
+	errStopWalk = errors.New("stop walk")
+)
+
+// Query is a compiled message filter. Queries are written as a sequence of
+// terms which must all match; terms may be combined with OR, negated with NOT
+// or a leading "-", and grouped with parentheses. Supported terms are:
+//
+//	from:addr  to:addr  cc:addr  subject:text  body:text
+//	before:2006-01-02  after:2006-01-02
+//	larger:10k  smaller:2M
+//	has:attachment
+//	text
+//
+// A bare text term matches the From, To, Cc and Subject headers and the body
+// text. Values containing spaces are written in double quotes. String matches
+// are case-insensitive substring matches, and dates are in UTC.
+type Query struct {
+	root queryNode
+	src  string
+}
+
+// ParseQuery compiles a query expression.
+func ParseQuery(s string) (*Query, error) {
+	p := &queryParser{tokens: tokenizeQuery(s)}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, p.tokens[p.pos])
+	}
+
+	if root == nil {
+		root = andNode{}
+	}
+
+	return &Query{root: root, src: s}, nil
+}
+
+// String returns the source of the query.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether the message read from r matches the query. Headers
+// are evaluated first; the body is only read when a term needs it.
+func (q *Query) Match(r io.Reader) (bool, error) {
+	m, err := newQueryMessage(r)
+	if err != nil {
+		return false, err
+	}
+
+	return q.root.match(m)
+}
+
+// Filter yields the messages of a Reader that match a Query.
+type Filter struct {
+	r *Reader
+	q *Query
+}
+
+// NewFilter returns a Filter reading messages from r. The position of the
+// current message is available from r.Index and r.Offset.
+func NewFilter(r *Reader, q *Query) *Filter {
+	return &Filter{r: r, q: q}
+}
+
+// NextMessage returns the next message that matches the query. It will return
+// io.EOF if there are no messages left.
+func (f *Filter) NextMessage() (io.Reader, error) {
+	for {
+		msg, err := f.r.NextMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		var seen bytes.Buffer
+		m, err := newQueryMessage(io.TeeReader(msg, &seen))
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := f.q.root.match(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return io.MultiReader(&seen, msg), nil
+		}
+	}
+}
+
+// queryMessage gives query terms access to a message, reading the body only
+// on demand.
+type queryMessage struct {
+	header     mail.Header
+	headerSize int64
+	br         *bufio.Reader
+
+	body     []byte
+	bodyRead bool
+	text     *string
+}
+
+func newQueryMessage(r io.Reader) (*queryMessage, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+
+	h, err := textproto.NewReader(br).ReadMIMEHeader()
+	var perr textproto.ProtocolError
+	switch {
+	case errors.As(err, &perr):
+		// The header is malformed: match the message as if it had none.
+		h = textproto.MIMEHeader{}
+	case err != nil && !(err == io.EOF && len(h) > 0):
+		return nil, err
+	}
+
+	return &queryMessage{header: mail.Header(h), headerSize: position(cr, br), br: br}, nil
+}
+
+func (m *queryMessage) readBody() ([]byte, error) {
+	if !m.bodyRead {
+		b, err := io.ReadAll(m.br)
+		if err != nil {
+			return nil, err
+		}
+		m.body, m.bodyRead = b, true
+	}
+
+	return m.body, nil
+}
+
+func (m *queryMessage) entity() (*entity, error) {
+	b, err := m.readBody()
+	if err != nil {
+		return nil, err
+	}
+
+	return newEntity(textproto.MIMEHeader(m.header), bytes.NewReader(b)), nil
+}
+
+func (m *queryMessage) bodyText() (string, error) {
+	if m.text == nil {
+		e, err := m.entity()
+		if err != nil {
+			return "", err
+		}
+
+		text, isHTML, err := extractText(e, &TextOptions{}, 0)
+		if err != nil {
+			return "", err
+		}
+		if isHTML {
+			text = htmlToText(text)
+		}
+
+		text = strings.ToLower(text)
+		m.text = &text
+	}
+
+	return *m.text, nil
+}
+
+func (m *queryMessage) headerText(names ...string) string {
+	var dec mime.WordDecoder
+	var parts []string
+	for _, name := range names {
+		for _, v := range m.header[textproto.CanonicalMIMEHeaderKey(name)] {
+			if d, err := dec.DecodeHeader(v); err == nil {
+				v = d
+			}
+			parts = append(parts, v)
+		}
+	}
+
+	return strings.ToLower(strings.Join(parts, "\n"))
+}
+
+type queryNode interface {
+	match(m *queryMessage) (bool, error)
+	needsBody() bool
+}
+
+type andNode []queryNode
+
+func (n andNode) match(m *queryMessage) (bool, error) {
+	for _, c := range n {
+		if ok, err := c.match(m); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (n andNode) needsBody() bool {
+	return anyNeedsBody(n)
+}
+
+type orNode []queryNode
+
+func (n orNode) match(m *queryMessage) (bool, error) {
+	for _, c := range n {
+		if ok, err := c.match(m); err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+func (n orNode) needsBody() bool {
+	return anyNeedsBody(n)
+}
+
+func anyNeedsBody(nodes []queryNode) bool {
+	for _, c := range nodes {
+		if c.needsBody() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerFirst orders nodes so that the ones which can be decided from the
+// headers alone are evaluated first.
+func headerFirst(nodes []queryNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return !nodes[i].needsBody() && nodes[j].needsBody()
+	})
+}
+
+type notNode struct {
+	n queryNode
+}
+
+func (n notNode) match(m *queryMessage) (bool, error) {
+	ok, err := n.n.match(m)
+
+	return !ok, err
+}
+
+func (n notNode) needsBody() bool {
+	return n.n.needsBody()
+}
+
+type headerNode struct {
+	names []string
+	value string
+}
+
+func (n headerNode) match(m *queryMessage) (bool, error) {
+	return strings.Contains(m.headerText(n.names...), n.value), nil
+}
+
+func (n headerNode) needsBody() bool {
+	return false
+}
+
+type bodyNode struct {
+	value string
+}
+
+func (n bodyNode) match(m *queryMessage) (bool, error) {
+	text, err := m.bodyText()
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(text, n.value), nil
+}
+
+func (n bodyNode) needsBody() bool {
+	return true
+}
+
+type dateNode struct {
+	before bool
+	t      time.Time
+}
+
+func (n dateNode) match(m *queryMessage) (bool, error) {
+	d, err := m.header.Date()
+	if err != nil {
+		return false, nil
+	}
+
+	if n.before {
+		return d.Before(n.t), nil
+	}
+
+	return !d.Before(n.t), nil
+}
+
+func (n dateNode) needsBody() bool {
+	return false
+}
+
+type sizeNode struct {
+	larger bool
+	size   int64
+}
+
+func (n sizeNode) match(m *queryMessage) (bool, error) {
+	b, err := m.readBody()
+	if err != nil {
+		return false, err
+	}
+
+	size := m.headerSize + int64(len(b))
+	if n.larger {
+		return size > n.size, nil
+	}
+
+	return size < n.size, nil
+}
+
+func (n sizeNode) needsBody() bool {
+	return true
+}
+
+type attachmentNode struct{}
+
+func (attachmentNode) match(m *queryMessage) (bool, error) {
+	e, err := m.entity()
+	if err != nil {
+		return false, err
+	}
+
+	err = e.walk(0, func(p *entity) error {
+		if p.isAttachment() {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		return true, nil
+	}
+
+	return false, err
+}
+
+func (attachmentNode) needsBody() bool {
+	return true
+}
+
+// tokenizeQuery splits a query into words and parentheses. Double quotes
+// group words and are removed.
+func tokenizeQuery(s string) []string {
+	var (
+		tokens []string
+		cur    strings.Builder
+		quoted bool
+		inWord bool
+	)
+
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+			inWord = false
+		}
+	}
+
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case quoted:
+			cur.WriteRune(c)
+		case unicode.IsSpace(c):
+			flush()
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	var nodes orNode
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			if len(nodes) > 0 {
+				return nil, fmt.Errorf("%w: missing term after OR", ErrInvalidQuery)
+			}
+			return nil, nil
+		}
+		nodes = append(nodes, n)
+
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	headerFirst(nodes)
+
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes andNode
+	for {
+		switch p.peek() {
+		case "", ")", "OR":
+			if len(nodes) == 0 {
+				return nil, nil
+			}
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			headerFirst(nodes)
+			return nodes, nil
+		case "AND":
+			p.pos++
+			continue
+		}
+
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok := p.peek()
+	switch {
+	case tok == "NOT":
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case tok == "(":
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrInvalidQuery)
+		}
+		p.pos++
+		if n == nil {
+			return nil, fmt.Errorf("%w: empty parentheses", ErrInvalidQuery)
+		}
+		return n, nil
+	case tok == "":
+		return nil, fmt.Errorf("%w: missing term", ErrInvalidQuery)
+	case len(tok) > 1 && tok[0] == '-':
+		p.tokens[p.pos] = tok[1:]
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+
+	p.pos++
+
+	return parseQueryTerm(tok)
+}
+
+func parseQueryTerm(tok string) (queryNode, error) {
+	field, value, ok := strings.Cut(tok, ":")
+	if !ok || value == "" {
+		v := strings.ToLower(tok)
+		return orNode{headerNode{names: []string{"From", "To", "Cc", "Subject"}, value: v}, bodyNode{value: v}}, nil
+	}
+
+	switch strings.ToLower(field) {
+	case "from":
+		return headerNode{names: []string{"From", "Sender"}, value: strings.ToLower(value)}, nil
+	case "to":
+		return headerNode{names: []string{"To", "Cc", "Bcc"}, value: strings.ToLower(value)}, nil
+	case "cc":
+		return headerNode{names: []string{"Cc"}, value: strings.ToLower(value)}, nil
+	case "subject":
+		return headerNode{names: []string{"Subject"}, value: strings.ToLower(value)}, nil
+	case "body":
+		return bodyNode{value: strings.ToLower(value)}, nil
+	case "before", "after":
+		t, err := parseQueryDate(value)
+		if err != nil {
+			return nil, err
+		}
+		return dateNode{before: strings.EqualFold(field, "before"), t: t}, nil
+	case "larger", "smaller":
+		n, err := parseQuerySize(value)
+		if err != nil {
+			return nil, err
+		}
+		return sizeNode{larger: strings.EqualFold(field, "larger"), size: n}, nil
+	case "has":
+		if strings.EqualFold(value, "attachment") {
+			return attachmentNode{}, nil
+		}
+		return nil, fmt.Errorf("%w: unknown has: value %q", ErrInvalidQuery, value)
+	}
+
+	return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, field)
+}
+
+func parseQueryDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: invalid date %q", ErrInvalidQuery, s)
+}
+
+func parseQuerySize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: invalid size %q", ErrInvalidQuery, s)
+	}
+
+	return n * mult, nil
+}
@@ -0,0 +1,145 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+const mboxQuery = `From alice@example.com Thu Jan  1 00:00:01 2015
+From: Alice <alice@example.com>
+To: Bob <bob@example.com>
+Date: Thu, 01 Jan 2015 00:00:01 +0000
+Subject: Quarterly report
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+See the attached figures.
+--b
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="q1.pdf"
+
+JVBERi0=
+--b--
+
+From bob@example.com Thu Feb  5 00:00:01 2015
+From: =?utf-8?q?B=C3=B6b?= <bob@example.com>
+To: Alice <alice@example.com>
+Cc: Carol <carol@example.com>
+Date: Thu, 05 Feb 2015 00:00:01 +0000
+Subject: Re: Quarterly report
+
+Thanks, the numbers look good.
+
+From carol@example.com Thu Mar  5 00:00:01 2015
+From: Carol <carol@example.com>
+To: Alice <alice@example.com>
+Date: Thu, 05 Mar 2015 00:00:01 +0000
+Subject: Lunch
+
+Pizza?
+`
+
+func querySubjects(t *testing.T, query string) []string {
+	q, err := ParseQuery(query)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q) = %v", query, err)
+	}
+
+	f := NewFilter(NewReader(strings.NewReader(mboxQuery)), q)
+
+	var subjects []string
+	for {
+		r, err := f.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		msg, err := mail.ReadMessage(r)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+		subjects = append(subjects, msg.Header.Get("Subject"))
+	}
+
+	return subjects
+}
+
+func TestQuery(t *testing.T) {
+	tests := map[string]string{
+		"":                                     "Quarterly report|Re: Quarterly report|Lunch",
+		"from:alice":                           "Quarterly report",
+		"from:böb":                             "Re: Quarterly report",
+		"to:carol":                             "Re: Quarterly report",
+		`subject:"quarterly report" -from:bob`: "Quarterly report",
+		"has:attachment":                       "Quarterly report",
+		"after:2015-02-01 before:2015-03-01":   "Re: Quarterly report",
+		"from:carol OR has:attachment":         "Quarterly report|Lunch",
+		"NOT (from:carol OR from:alice)":       "Re: Quarterly report",
+		"body:numbers":                         "Re: Quarterly report",
+		"pizza":                                "Lunch",
+		"larger:150 AND smaller:1k":            "Quarterly report|Re: Quarterly report",
+		"smaller:150":                          "Lunch",
+	}
+
+	for query, want := range tests {
+		if got := strings.Join(querySubjects(t, query), "|"); got != want {
+			t.Errorf("%q - Expected %q, got %q", query, want, got)
+		}
+	}
+}
+
+func TestQueryHeadersFirst(t *testing.T) {
+	q, err := ParseQuery("has:attachment from:nobody")
+	if err != nil {
+		t.Fatalf("ParseQuery() = %v", err)
+	}
+
+	// The body is malformed, but from: rejects the message before it is read.
+	msg := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\nbroken"
+	ok, err := q.Match(strings.NewReader(msg))
+	if err != nil || ok {
+		t.Errorf("Match() = %v, %v", ok, err)
+	}
+}
+
+func TestFilterMalformedHeader(t *testing.T) {
+	data := "From a@example.com Thu Jan  1 00:00:01 2015\nFrom: a@example.com\nSubject: Broken\nnot a header\n\nMeeting notes.\n\n" + mboxQuery
+
+	for query, want := range map[string]int{"": 4, "from:alice": 1, "-from:alice": 3, "meeting": 1} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery() = %v", err)
+		}
+
+		f := NewFilter(NewReader(strings.NewReader(data)), q)
+		n := 0
+		for {
+			_, err := f.NextMessage()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%q - Unexpected error after NextMessage(): %v", query, err)
+			}
+			n++
+		}
+
+		if n != want {
+			t.Errorf("%q - Expected %d messages, got %d", query, want, n)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"(from:a", "from:a OR", "foo:bar", "larger:xyz", "before:yesterday", "has:nothing", ")"} {
+		if _, err := ParseQuery(query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseQuery(%q) = %v, want ErrInvalidQuery", query, err)
+		}
+	}
+}
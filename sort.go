@@ -0,0 +1,370 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/mail"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSortKey = errors.New("invalid sort key")
+
+// SortKey selects the order produced by Sort.
+type SortKey int
+
+const (
+	// SortByDate orders messages by their Date header. Messages without a
+	// valid one use the date of their separator line instead.
+	SortByDate SortKey = iota
+	// SortByDeliveryDate orders messages by the date of their separator line.
+	SortByDeliveryDate
+	// SortBySender orders messages by the address in their From header,
+	// ignoring case.
+	SortBySender
+	// SortBySubject orders messages by their Subject header, ignoring case
+	// and reply and forward prefixes.
+	SortBySubject
+	// SortBySize orders messages by their size in the mbox.
+	SortBySize
+)
+
+// SortOptions configures Sort.
+type SortOptions struct {
+	// Key is the sort key. It defaults to SortByDate.
+	Key SortKey
+
+	// Reverse sorts in descending order. Messages with equal keys stay in
+	// their original order either way.
+	Reverse bool
+
+	// MaxMemoryKeys bounds the number of keys held in memory; beyond it
+	// sorted runs of keys are written to temporary files in TempDir and
+	// merged. It defaults to DefaultMaxMemoryKeys.
+	MaxMemoryKeys int
+
+	// TempDir is the directory for the sorted runs. It defaults to
+	// os.TempDir().
+	TempDir string
+}
+
+// sortRecord locates a message in the input together with its sort key.
+type sortRecord struct {
+	key    []byte
+	index  int
+	offset int64
+	size   int64
+}
+
+// Sort writes the messages of the mbox data read from r to w, ordered by
+// opts.Key. A nil opts is the same as the zero value. It returns the number of
+// messages written.
+//
+// Only the keys and offsets of the messages are kept in memory, at most
+// opts.MaxMemoryKeys of them at a time, and message data is copied from r
+// unchanged, so archives larger than the available memory can be sorted. A
+// blank line is added after the last message of the input if it lacks one.
+func Sort(w io.Writer, r io.ReaderAt, opts *SortOptions) (int, error) {
+	s := &sorter{}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Key < SortByDate || s.opts.Key > SortBySize {
+		return 0, ErrInvalidSortKey
+	}
+	if s.opts.MaxMemoryKeys <= 0 {
+		s.opts.MaxMemoryKeys = DefaultMaxMemoryKeys
+	}
+	defer s.close()
+
+	if err := s.scan(NewReader(io.NewSectionReader(r, 0, math.MaxInt64))); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	err := s.merge(func(rec *sortRecord) error {
+		data := io.NewSectionReader(r, rec.offset, rec.size)
+		if _, err := io.Copy(w, data); err != nil {
+			return err
+		}
+
+		pad, err := missingNewlines(data, rec.size)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, pad); err != nil {
+			return err
+		}
+
+		n++
+
+		return nil
+	})
+
+	return n, err
+}
+
+type sorter struct {
+	opts SortOptions
+	recs []*sortRecord
+	runs []*os.File
+}
+
+// scan reads the keys of all messages of r, spilling sorted runs of them as
+// needed.
+func (s *sorter) scan(r *Reader) error {
+	var last *sortRecord
+	for {
+		msg, err := r.NextMessage()
+		if last != nil {
+			// A message ends where the next one starts.
+			end := r.Offset()
+			if err == io.EOF {
+				end = r.cr.n
+			}
+			last.size = end - last.offset
+
+			if err := s.add(last); err != nil {
+				return err
+			}
+			last = nil
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		key, err := s.key(r, msg)
+		if err != nil {
+			return err
+		}
+
+		last = &sortRecord{key: key, index: r.Index(), offset: r.Offset()}
+	}
+}
+
+// key returns the sort key of the message msg most recently returned by r,
+// encoded so that keys compare with bytes.Compare.
+func (s *sorter) key(r *Reader, msg io.Reader) ([]byte, error) {
+	if s.opts.Key == SortBySize {
+		// The size is only known once the next message is found.
+		return nil, nil
+	}
+
+	_, delivered, _ := ParseSeparator(r.Separator())
+	if s.opts.Key == SortByDeliveryDate {
+		return timeKey(delivered), nil
+	}
+
+	m, err := newQueryMessage(msg)
+	if err != nil {
+		// The header is malformed: sort the message as if it had none.
+		m = &queryMessage{header: mail.Header{}}
+	}
+
+	switch s.opts.Key {
+	case SortBySender:
+		from := m.header.Get("From")
+		if addr, err := mail.ParseAddress(from); err == nil {
+			from = addr.Address
+		}
+		return []byte(strings.ToLower(strings.TrimSpace(from))), nil
+	case SortBySubject:
+		subject, _ := baseSubject(m.headerText("Subject"))
+		return []byte(subject), nil
+	}
+
+	if t, err := m.header.Date(); err == nil {
+		return timeKey(t), nil
+	}
+
+	return timeKey(delivered), nil
+}
+
+// timeKey encodes t so that earlier times compare lower.
+func timeKey(t time.Time) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b, uint64(t.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(b[8:], uint32(t.Nanosecond()))
+
+	return b
+}
+
+// add collects rec, writing the collected records to a new run once there are
+// too many of them.
+func (s *sorter) add(rec *sortRecord) error {
+	if s.opts.Key == SortBySize {
+		rec.key = binary.BigEndian.AppendUint64(nil, uint64(rec.size))
+	}
+
+	s.recs = append(s.recs, rec)
+	if len(s.recs) < s.opts.MaxMemoryKeys {
+		return nil
+	}
+
+	return s.spill()
+}
+
+func (s *sorter) compare(a, b *sortRecord) int {
+	c := bytes.Compare(a.key, b.key)
+	if s.opts.Reverse {
+		c = -c
+	}
+	if c == 0 {
+		return cmp.Compare(a.index, b.index)
+	}
+
+	return c
+}
+
+// spill writes the collected records to a temporary file, sorted.
+func (s *sorter) spill() error {
+	slices.SortFunc(s.recs, s.compare)
+
+	f, err := os.CreateTemp(s.opts.TempDir, "mbox-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+
+	w := bufio.NewWriter(f)
+	var buf []byte
+	for _, rec := range s.recs {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(rec.key)))
+		buf = append(buf, rec.key...)
+		buf = binary.AppendUvarint(buf, uint64(rec.index))
+		buf = binary.AppendUvarint(buf, uint64(rec.offset))
+		buf = binary.AppendUvarint(buf, uint64(rec.size))
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	s.recs = s.recs[:0]
+
+	return nil
+}
+
+// sortRun reads back the records of a run.
+type sortRun struct {
+	r   *bufio.Reader
+	cur *sortRecord
+}
+
+func (run *sortRun) next() error {
+	n, err := binary.ReadUvarint(run.r)
+	if err == io.EOF {
+		run.cur = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	rec := &sortRecord{key: make([]byte, n)}
+	if _, err := io.ReadFull(run.r, rec.key); err != nil {
+		return err
+	}
+
+	var vals [3]uint64
+	for i := range vals {
+		if vals[i], err = binary.ReadUvarint(run.r); err != nil {
+			return err
+		}
+	}
+	rec.index, rec.offset, rec.size = int(vals[0]), int64(vals[1]), int64(vals[2])
+	run.cur = rec
+
+	return nil
+}
+
+// merge calls fn with every record in order, merging the runs written by
+// spill with the records still in memory.
+func (s *sorter) merge(fn func(*sortRecord) error) error {
+	if len(s.runs) == 0 {
+		slices.SortFunc(s.recs, s.compare)
+		for _, rec := range s.recs {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.recs) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	h := &sortHeap{s: s}
+	for _, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		run := &sortRun{r: bufio.NewReader(f)}
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.cur != nil {
+			h.runs = append(h.runs, run)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		run := h.runs[0]
+		if err := fn(run.cur); err != nil {
+			return err
+		}
+
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.cur == nil {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+
+	return nil
+}
+
+// close removes the temporary files.
+func (s *sorter) close() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// sortHeap orders runs by their current record.
+type sortHeap struct {
+	s    *sorter
+	runs []*sortRun
+}
+
+func (h *sortHeap) Len() int           { return len(h.runs) }
+func (h *sortHeap) Less(i, j int) bool { return h.s.compare(h.runs[i].cur, h.runs[j].cur) < 0 }
+func (h *sortHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *sortHeap) Push(x any)         { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *sortHeap) Pop() any {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+
+	return run
+}
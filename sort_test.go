@@ -0,0 +1,106 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mboxUnsorted = `From carol@example.com Sat Jan  3 00:00:01 2015
+From: Carol <carol@example.com>
+Date: Sat, 03 Jan 2015 00:00:01 +0000
+Subject: Re: Budget
+
+Carol replies at length, adding enough text to be the largest message.
+
+From bob@example.com Fri Jan  2 00:00:01 2015
+From: Bob <Bob@example.com>
+Date: Thu, 01 Jan 2015 00:00:01 +0000
+Subject: Agenda
+
+Bob.
+
+From alice@example.com Sun Jan  4 00:00:01 2015
+From: Alice <alice@example.com>
+Subject: Coffee
+
+No Date header.
+
+From dave@example.com Thu Jan  1 12:00:01 2015
+From: Dave <dave@example.com>
+Date: Fri, 02 Jan 2015 00:00:01 +0000
+Subject: budget
+
+Dave.`
+
+func sortedSenders(t *testing.T, mbox string) []string {
+	t.Helper()
+
+	r := NewReader(strings.NewReader(mbox))
+
+	var senders []string
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return senders
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+
+		addr, _ := mail.ParseAddress(m.Header.Get("From"))
+		senders = append(senders, addr.Name)
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		opts SortOptions
+		want []string
+	}{
+		{SortOptions{}, []string{"Bob", "Dave", "Carol", "Alice"}},
+		{SortOptions{Reverse: true}, []string{"Alice", "Carol", "Dave", "Bob"}},
+		{SortOptions{Key: SortByDeliveryDate}, []string{"Dave", "Bob", "Carol", "Alice"}},
+		{SortOptions{Key: SortBySender}, []string{"Alice", "Bob", "Carol", "Dave"}},
+		{SortOptions{Key: SortBySubject}, []string{"Bob", "Carol", "Dave", "Alice"}},
+		{SortOptions{Key: SortBySubject, Reverse: true}, []string{"Alice", "Carol", "Dave", "Bob"}},
+		{SortOptions{Key: SortBySize}, []string{"Alice", "Bob", "Dave", "Carol"}},
+	}
+
+	for i, tt := range tests {
+		for _, max := range []int{0, 1, 3} {
+			opts := tt.opts
+			opts.MaxMemoryKeys = max
+			opts.TempDir = t.TempDir()
+
+			var buf bytes.Buffer
+			n, err := Sort(&buf, strings.NewReader(mboxUnsorted), &opts)
+			if err != nil {
+				t.Fatalf("%d, %d - Sort() = %v", i, max, err)
+			}
+			if n != len(tt.want) {
+				t.Errorf("%d, %d - Expected %d messages, got %d", i, max, len(tt.want), n)
+			}
+
+			if got := sortedSenders(t, buf.String()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%d, %d - Expected %v, got %v", i, max, tt.want, got)
+			}
+			if !strings.Contains(buf.String(), "\nDave.\n\nFrom ") && !strings.HasSuffix(buf.String(), "\nDave.\n\n") {
+				t.Errorf("%d, %d - Missing blank line after the last message:\n%s", i, max, buf.String())
+			}
+		}
+	}
+}
+
+func TestSortInvalidKey(t *testing.T) {
+	if _, err := Sort(io.Discard, strings.NewReader(mboxUnsorted), &SortOptions{Key: SortBySize + 1}); err != ErrInvalidSortKey {
+		t.Errorf("Expected ErrInvalidSortKey, got %v", err)
+	}
+}
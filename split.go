@@ -0,0 +1,339 @@
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultSplitName is the file name template used by Split when none is given.
+const DefaultSplitName = `{{with .Key}}{{.}}-{{end}}{{printf "%04d" .Part}}.mbox`
+
+// splitMaxOpen is the number of output files Split and SplitGmailLabels keep
+// open.
+const splitMaxOpen = 64
+
+// SplitKeyFunc returns the group of a message for Split, from its separator
+// line and header. Every group is written to its own files.
+type SplitKeyFunc func(separator string, h mail.Header) string
+
+// SplitName holds the values available to a Split file name template.
+type SplitName struct {
+	// Key is the group of the messages in the file, reduced to characters
+	// that are safe in file names, or empty if Split does not group them.
+	Key string
+	// Part is the one-based number of the file within its group.
+	Part int
+}
+
+// SplitOptions configures Split.
+type SplitOptions struct {
+	// MaxBytes, if positive, starts a new file before a message which would
+	// make the current one larger. A message larger than MaxBytes is
+	// written to a file of its own.
+	MaxBytes int64
+
+	// MaxMessages, if positive, is the number of messages per file.
+	MaxMessages int
+
+	// Key, if non-nil, groups the messages into separate files, such as
+	// SplitByMonth or SplitByHeader("List-Id"). Messages for which it
+	// returns an empty string form the group with an empty Key.
+	Key SplitKeyFunc
+
+	// Name is a text/template producing the file name of each file from a
+	// SplitName, relative to the output directory. It defaults to
+	// DefaultSplitName. Names which are already taken get a numeric suffix.
+	Name string
+
+	// Variant is the variant of both the source and the output mboxes.
+	Variant Variant
+}
+
+type splitPart struct {
+	file  string
+	name  string
+	part  int
+	count int
+	size  int64
+}
+
+// Split writes the messages read from r to several mbox files in dir,
+// creating dir if needed: a new file is started whenever the current one
+// reaches opts.MaxBytes or opts.MaxMessages, and messages are grouped by
+// opts.Key. Messages are never split across files. Existing files are
+// replaced. It returns the names of the files written, relative to dir, in
+// the order they were started.
+func Split(r *Reader, dir string, opts *SplitOptions) ([]string, error) {
+	if opts == nil {
+		opts = &SplitOptions{}
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = DefaultSplitName
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := newFileSplitter(dir)
+	defer s.closeAll()
+
+	parts := map[string]*splitPart{}
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return s.files, err
+		}
+
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			return s.files, err
+		}
+
+		header, body := splitMessage(normalizeNewlines(b))
+		out, err := reformatMessage(r.Separator(), header, body, opts.Variant)
+		if err != nil {
+			return s.files, err
+		}
+
+		key := ""
+		if opts.Key != nil {
+			h := mail.Header{}
+			if m, err := mail.ReadMessage(bytes.NewReader(append(header, '\n'))); err == nil {
+				h = m.Header
+			}
+			if key = opts.Key(r.Separator(), h); key != "" {
+				key = sanitizeName(key)
+			}
+		}
+
+		p := parts[key]
+		if p == nil || p.full(opts, len(out)) {
+			next := &splitPart{part: 1}
+			if p != nil {
+				if err := s.close(p.file); err != nil {
+					return s.files, err
+				}
+				next.part = p.part + 1
+			}
+
+			var fn bytes.Buffer
+			if err := tmpl.Execute(&fn, SplitName{Key: key, Part: next.part}); err != nil {
+				return s.files, err
+			}
+
+			next.file = key + "\x00" + strconv.Itoa(next.part)
+			next.name = fn.String()
+			p, parts[key] = next, next
+		}
+
+		if err := s.write(p.file, p.name, out); err != nil {
+			return s.files, err
+		}
+		p.count++
+		p.size += int64(len(out))
+	}
+
+	return s.files, s.closeAll()
+}
+
+// full reports whether a message of n bytes must go to a new file.
+func (p *splitPart) full(opts *SplitOptions, n int) bool {
+	if p.count == 0 {
+		return false
+	}
+
+	return (opts.MaxMessages > 0 && p.count >= opts.MaxMessages) ||
+		(opts.MaxBytes > 0 && p.size+int64(n) > opts.MaxBytes)
+}
+
+// SplitByYear groups messages by the year of their Date header, or of their
+// separator line if it has none, such as "2015".
+func SplitByYear(separator string, h mail.Header) string {
+	return formatMessageDate(separator, h, "2006")
+}
+
+// SplitByMonth groups messages by the month of their Date header, or of their
+// separator line if it has none, such as "2015-01".
+func SplitByMonth(separator string, h mail.Header) string {
+	return formatMessageDate(separator, h, "2006-01")
+}
+
+func formatMessageDate(separator string, h mail.Header, layout string) string {
+	t, err := h.Date()
+	if err != nil {
+		_, t, _ = ParseSeparator(separator)
+	}
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(layout)
+}
+
+// SplitBySenderDomain groups messages by the lower-cased domain of the address
+// in their From header, or of the envelope sender if it has none.
+func SplitBySenderDomain(separator string, h mail.Header) string {
+	from := ""
+	if addr, err := mail.ParseAddress(h.Get("From")); err == nil {
+		from = addr.Address
+	} else {
+		from, _, _ = ParseSeparator(separator)
+	}
+
+	i := strings.LastIndexByte(from, '@')
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(from[i+1:])
+}
+
+// SplitByHeader returns a SplitKeyFunc grouping messages by the decoded value
+// of the named header field. If the value contains an identifier in angle
+// brackets, as List-Id does, only the identifier is used.
+func SplitByHeader(name string) SplitKeyFunc {
+	return func(separator string, h mail.Header) string {
+		v := h.Get(name)
+		if d, err := new(mime.WordDecoder).DecodeHeader(v); err == nil {
+			v = d
+		}
+
+		if i := strings.LastIndexByte(v, '<'); i >= 0 {
+			if j := strings.IndexByte(v[i:], '>'); j > 0 {
+				v = v[i+1 : i+j]
+			}
+		}
+
+		return strings.TrimSpace(v)
+	}
+}
+
+// reformatMessage formats a message read from an mbox of variant v, split into
+// its header and body, for writing to another mbox of the same variant with
+// the same separator line.
+func reformatMessage(separator string, header, body []byte, v Variant) ([]byte, error) {
+	sender, date, _ := ParseSeparator(separator)
+
+	var out bytes.Buffer
+	w := NewVariantWriter(&out, v)
+	if err := w.WriteMessage(sender, date, bytes.NewReader(joinMessage(header, v.unescape(body)))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// fileSplitter appends messages to several files below dir, keeping a bounded
+// number of them open. Files are identified by a key, and replaced when they
+// are first written to.
+type fileSplitter struct {
+	dir   string
+	names map[string]string
+	used  map[string]bool
+	open  map[string]*os.File
+	files []string
+}
+
+func newFileSplitter(dir string) *fileSplitter {
+	return &fileSplitter{dir: dir, names: map[string]string{}, used: map[string]bool{}, open: map[string]*os.File{}}
+}
+
+// write appends data to the file of key, which is created with an unused name
+// based on name on first use.
+func (s *fileSplitter) write(key, name string, data []byte) error {
+	f, ok := s.open[key]
+	if !ok {
+		if len(s.open) >= splitMaxOpen {
+			if err := s.closeAll(); err != nil {
+				return err
+			}
+		}
+
+		flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		path, ok := s.names[key]
+		if !ok {
+			var err error
+			if path, err = s.fileName(name); err != nil {
+				return err
+			}
+			s.names[key] = path
+			s.files = append(s.files, path)
+			flag |= os.O_TRUNC
+		}
+
+		full := filepath.Join(s.dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			return err
+		}
+
+		var err error
+		if f, err = os.OpenFile(full, flag, 0o644); err != nil {
+			return err
+		}
+		s.open[key] = f
+	}
+
+	_, err := f.Write(data)
+
+	return err
+}
+
+// fileName returns an unused file name based on name. Distinct keys may be
+// sanitized to the same name, such as "a/b" and "a_b".
+func (s *fileSplitter) fileName(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("split file name %q is outside the output directory", name)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; s.used[strings.ToLower(name)]; i++ {
+		name = base + "-" + strconv.Itoa(i) + ext
+	}
+	s.used[strings.ToLower(name)] = true
+
+	return name, nil
+}
+
+// close closes the file of key, if it is open.
+func (s *fileSplitter) close(key string) error {
+	f, ok := s.open[key]
+	if !ok {
+		return nil
+	}
+	delete(s.open, key)
+
+	return f.Close()
+}
+
+func (s *fileSplitter) closeAll() error {
+	var err error
+	for key := range s.open {
+		if cerr := s.close(key); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
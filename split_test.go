@@ -0,0 +1,127 @@
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mboxToSplit = `From alice@example.com Thu Jan  1 00:00:01 2015
+From: Alice <alice@Example.com>
+Date: Thu, 01 Jan 2015 00:00:01 +0000
+List-Id: Go Nuts <golang-nuts.googlegroups.com>
+Subject: One
+
+>From here on, this is escaped.
+
+From bob@example.org Fri Jan  2 00:00:01 2015
+From: Bob <bob@example.org>
+Subject: Two
+
+No Date header: the separator date is used.
+
+From carol@example.com Sun Feb  1 00:00:01 2015
+From: Carol <carol@example.com>
+Date: Sun, 01 Feb 2015 00:00:01 +0000
+List-Id: <golang-nuts.googlegroups.com>
+Subject: Three
+
+Three.
+`
+
+func readSplitFile(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var subjects []string
+	r := NewReader(f)
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return subjects
+		} else if err != nil {
+			t.Fatalf("%s - Unexpected error after NextMessage(): %v", path, err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("%s - mail.ReadMessage() = %v", path, err)
+		}
+		subjects = append(subjects, m.Header.Get("Subject"))
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		opts SplitOptions
+		want map[string][]string
+	}{
+		{SplitOptions{}, map[string][]string{"0001.mbox": {"One", "Two", "Three"}}},
+		{SplitOptions{MaxMessages: 2}, map[string][]string{"0001.mbox": {"One", "Two"}, "0002.mbox": {"Three"}}},
+		{SplitOptions{MaxBytes: 320}, map[string][]string{"0001.mbox": {"One"}, "0002.mbox": {"Two", "Three"}}},
+		{SplitOptions{MaxBytes: 1}, map[string][]string{"0001.mbox": {"One"}, "0002.mbox": {"Two"}, "0003.mbox": {"Three"}}},
+		{
+			SplitOptions{Key: SplitByMonth, Name: "{{.Key}}/{{.Part}}.mbox"},
+			map[string][]string{"2015-01/1.mbox": {"One", "Two"}, "2015-02/1.mbox": {"Three"}},
+		},
+		{
+			SplitOptions{Key: SplitByYear, MaxMessages: 2},
+			map[string][]string{"2015-0001.mbox": {"One", "Two"}, "2015-0002.mbox": {"Three"}},
+		},
+		{
+			SplitOptions{Key: SplitBySenderDomain},
+			map[string][]string{"example.com-0001.mbox": {"One", "Three"}, "example.org-0001.mbox": {"Two"}},
+		},
+		{
+			SplitOptions{Key: SplitByHeader("List-Id"), Name: `{{or .Key "other"}}.mbox`},
+			map[string][]string{"golang-nuts.googlegroups.com.mbox": {"One", "Three"}, "other.mbox": {"Two"}},
+		},
+	}
+
+	for i, tt := range tests {
+		dir := t.TempDir()
+		files, err := Split(NewReader(strings.NewReader(mboxToSplit)), dir, &tt.opts)
+		if err != nil {
+			t.Fatalf("%d - Split() = %v", i, err)
+		}
+
+		got := map[string][]string{}
+		for _, name := range files {
+			got[filepath.ToSlash(name)] = readSplitFile(t, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d - Expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestSplitEscaping(t *testing.T) {
+	dir := t.TempDir()
+	files, err := Split(NewReader(strings.NewReader(mboxToSplit)), dir, &SplitOptions{MaxMessages: 1})
+	if err != nil {
+		t.Fatalf("Split() = %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mboxToSplit[:strings.Index(mboxToSplit, "From bob")]; string(b) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, b)
+	}
+}
+
+func TestSplitOutsideDirectory(t *testing.T) {
+	if _, err := Split(NewReader(strings.NewReader(mboxToSplit)), t.TempDir(), &SplitOptions{Name: "../{{.Part}}.mbox"}); err == nil {
+		t.Error("Expected an error for a file name outside the directory")
+	}
+}
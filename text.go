@@ -0,0 +1,335 @@
+package mbox
+
+import (
+	"html"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// TextOptions controls how TextBody extracts the readable text of a message.
+type TextOptions struct {
+	// StripQuotes removes quoted reply blocks, their attribution lines
+	// ("On ... wrote:") and forwarded "Original Message" sections.
+	StripQuotes bool
+
+	// StripSignature removes everything after a "-- " signature separator.
+	StripSignature bool
+
+	// CharsetReader, if non-nil, converts text in charsets other than UTF-8,
+	// US-ASCII and ISO-8859-1 to UTF-8.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+}
+
+var (
+	reAttribution   = regexp.MustCompile(`(?i)^(on\s.+|.+\s)wrote:$`)
+	reOriginalMsg   = regexp.MustCompile(`(?i)^-{2,}\s*(original message|forwarded message)\s*-{2,}$`)
+	reHref          = regexp.MustCompile(`(?is)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	reAlt           = regexp.MustCompile(`(?is)\balt\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	reAnySpace      = regexp.MustCompile(`\s+`)
+	reHorizontalSpc = regexp.MustCompile(`[ \t\f\v\x{00a0}]+`)
+)
+
+// TextBody returns the readable text of the message read from r, typically a
+// message returned by Reader.NextMessage. The text/plain alternative is
+// preferred; when a message only has HTML, it is converted to plain text with
+// scripts and styles dropped and link targets kept. Whitespace is normalized.
+// A nil opts is the same as the zero value.
+func TextBody(r io.Reader, opts *TextOptions) (string, error) {
+	if opts == nil {
+		opts = &TextOptions{}
+	}
+
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return "", err
+	}
+
+	text, isHTML, err := extractText(newEntity(textproto.MIMEHeader(msg.Header), msg.Body), opts, 0)
+	if err != nil {
+		return "", err
+	}
+
+	if isHTML {
+		text = htmlToText(text)
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if opts.StripSignature {
+		text = stripSignature(text)
+	}
+	if opts.StripQuotes {
+		text = stripQuotes(text)
+	}
+
+	return normalizeWhitespace(text), nil
+}
+
+// extractText returns the preferred text of an entity and whether it is HTML.
+func extractText(e *entity, opts *TextOptions, depth int) (string, bool, error) {
+	if !e.isMultipart() {
+		if e.isAttachment() {
+			return "", false, nil
+		}
+
+		switch e.mediaType {
+		case "text/plain", "text/html":
+			text, err := e.text(opts.CharsetReader)
+			return text, e.mediaType == "text/html", err
+		}
+
+		return "", false, nil
+	}
+
+	if depth >= maxPartDepth {
+		return "", false, errPartTooDeep
+	}
+
+	var (
+		texts      []string
+		plain, htm string
+	)
+
+	err := e.parts(func(p *entity) error {
+		text, isHTML, err := extractText(p, opts, depth+1)
+		if err != nil || strings.TrimSpace(text) == "" {
+			return err
+		}
+
+		if e.mediaType == "multipart/alternative" {
+			if isHTML && htm == "" {
+				htm = text
+			} else if !isHTML && plain == "" {
+				plain = text
+			}
+		} else {
+			if isHTML {
+				text = htmlToText(text)
+			}
+			texts = append(texts, text)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if e.mediaType == "multipart/alternative" {
+		if plain != "" {
+			return plain, false, nil
+		}
+		return htm, htm != "", nil
+	}
+
+	return strings.Join(texts, "\n\n"), false, nil
+}
+
+// stripSignature removes the signature block starting at the first "-- "
+// separator line.
+func stripSignature(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		if strings.TrimRight(l, " ") == "--" {
+			return strings.Join(lines[:i], "\n")
+		}
+	}
+
+	return text
+}
+
+// stripQuotes removes lines quoted with ">", the attribution line introducing
+// them and any forwarded or original message section.
+func stripQuotes(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		if reOriginalMsg.MatchString(t) {
+			break
+		}
+
+		if strings.HasPrefix(t, ">") {
+			// Drop the attribution line (and blank lines) before the quote.
+			for len(out) > 0 {
+				last := strings.TrimSpace(out[len(out)-1])
+				if last != "" && !reAttribution.MatchString(last) {
+					break
+				}
+				out = out[:len(out)-1]
+				if last != "" {
+					break
+				}
+			}
+			continue
+		}
+
+		out = append(out, l)
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// normalizeWhitespace collapses horizontal whitespace, trims every line and
+// reduces runs of blank lines to a single one.
+func normalizeWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	blank := true
+
+	for _, l := range lines {
+		l = strings.TrimSpace(reHorizontalSpc.ReplaceAllString(l, " "))
+		if l == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+
+		out = append(out, l)
+		blank = false
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// htmlToText converts an HTML document to plain text. It is deliberately
+// forgiving: tags are recognised lexically, without building a document tree.
+func htmlToText(s string) string {
+	var (
+		sb      strings.Builder
+		skip    string
+		pre     int
+		quote   int
+		href    string
+		linkPos int
+		bol     = true
+	)
+
+	write := func(t string) {
+		for _, c := range t {
+			if c == '\n' {
+				sb.WriteByte('\n')
+				bol = true
+				continue
+			}
+
+			if bol {
+				if c == ' ' {
+					continue
+				}
+				sb.WriteString(strings.Repeat("> ", quote))
+				bol = false
+			}
+			sb.WriteRune(c)
+		}
+	}
+
+	for len(s) > 0 {
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+
+		if s[0] != '<' {
+			end := strings.IndexByte(s, '<')
+			if end < 0 {
+				end = len(s)
+			}
+
+			if skip == "" {
+				text := s[:end]
+				if pre == 0 {
+					text = reAnySpace.ReplaceAllString(text, " ")
+				}
+				text = html.UnescapeString(text)
+				write(text)
+			}
+
+			s = s[end:]
+			continue
+		}
+
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+
+		tag := s[1:end]
+		s = s[end+1:]
+
+		closing := strings.HasPrefix(tag, "/")
+		name := strings.ToLower(strings.TrimLeft(tag, "/"))
+		if i := strings.IndexAny(name, " \t\r\n/"); i >= 0 {
+			name = name[:i]
+		}
+
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+			continue
+		}
+
+		switch name {
+		case "script", "style", "head", "title", "noscript":
+			if !closing {
+				skip = name
+			}
+		case "br":
+			write("\n")
+		case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "table", "ul", "ol", "hr", "section", "article", "header", "footer":
+			write("\n\n")
+		case "tr", "dt", "dd":
+			write("\n")
+		case "td", "th":
+			write(" ")
+		case "li":
+			if !closing {
+				write("\n- ")
+			}
+		case "pre":
+			write("\n")
+			if closing && pre > 0 {
+				pre--
+			} else if !closing {
+				pre++
+			}
+		case "blockquote":
+			write("\n")
+			if closing && quote > 0 {
+				quote--
+			} else if !closing {
+				quote++
+			}
+		case "img":
+			if m := reAlt.FindStringSubmatch(tag); m != nil {
+				write(html.UnescapeString(m[1] + m[2] + m[3]))
+			}
+		case "a":
+			if closing {
+				text := strings.TrimSpace(sb.String()[linkPos:])
+				if href != "" && text != href && strings.TrimPrefix(href, "mailto:") != text {
+					write(" (" + href + ")")
+				}
+				href = ""
+			} else if m := reHref.FindStringSubmatch(tag); m != nil {
+				href = strings.TrimSpace(html.UnescapeString(m[1] + m[2] + m[3]))
+				if strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+					href = ""
+				}
+				linkPos = sb.Len()
+			}
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,102 @@
+package mbox
+
+import (
+	"strings"
+	"testing"
+)
+
+const mboxTextBodies = `From alice@example.com Thu Jan  1 00:00:01 2015
+From: Alice <alice@example.com>
+Subject: Plain
+Content-Type: multipart/alternative; boundary="b1"
+
+--b1
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Hello   Bob,=20
+this is the plain=
+ part.
+
+On Wed, 31 Dec 2014, Bob wrote:
+> Did you get it?
+> Please answer.
+
+--=20
+Alice
+--b1
+Content-Type: text/html; charset=utf-8
+
+<p>Hello Bob, this is the <b>HTML</b> part.</p>
+--b1--
+
+From bob@example.com Thu Jan  1 00:00:02 2015
+From: Bob <bob@example.com>
+Subject: HTML only
+Content-Type: multipart/mixed; boundary="b2"
+
+--b2
+Content-Type: text/html; charset=iso-8859-1
+Content-Transfer-Encoding: base64
+
+PGh0bWw+PGhlYWQ+PHRpdGxlPlQ8L3RpdGxlPjxzdHlsZT5wIHtjb2xvcjogcmVkfTwvc3R5bGU+
+PC9oZWFkPjxib2R5PjxwPkNhZukgJmFtcDsgPGEgaHJlZj0iaHR0cHM6Ly9leGFtcGxlLmNvbS8i
+Pm1lbnU8L2E+PC9wPjxzY3JpcHQ+YWxlcnQoMSk8L3NjcmlwdD48YmxvY2txdW90ZT5vbGQ8L2Js
+b2NrcXVvdGU+PHA+RW5kPC9wPjwvYm9keT48L2h0bWw+
+--b2
+Content-Type: text/plain
+Content-Disposition: attachment; filename="notes.txt"
+
+Attached notes.
+--b2--
+`
+
+func TestTextBody(t *testing.T) {
+	tests := []struct {
+		opts *TextOptions
+		want []string
+	}{
+		{
+			opts: nil,
+			want: []string{
+				"Hello Bob,\nthis is the plain part.\n\nOn Wed, 31 Dec 2014, Bob wrote:\n> Did you get it?\n> Please answer.\n\n--\nAlice",
+				"Café & menu (https://example.com/)\n\n> old\n\nEnd",
+			},
+		},
+		{
+			opts: &TextOptions{StripQuotes: true, StripSignature: true},
+			want: []string{
+				"Hello Bob,\nthis is the plain part.",
+				"Café & menu (https://example.com/)\n\nEnd",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		m := NewReader(strings.NewReader(mboxTextBodies))
+		for i, want := range tt.want {
+			r, err := m.NextMessage()
+			if err != nil {
+				t.Fatalf("Unexpected error after NextMessage(): %v", err)
+			}
+
+			got, err := TextBody(r, tt.opts)
+			if err != nil {
+				t.Fatalf("TextBody() = %v", err)
+			}
+
+			if got != want {
+				t.Errorf("%d - Expected:\n%q\ngot\n%q", i, want, got)
+			}
+		}
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	in := `<ul><li>One</li><li>Two <a href="mailto:x@example.com">x@example.com</a></li></ul><pre>a  b</pre>`
+	want := "- One\n- Two x@example.com\n\na b"
+
+	if got := normalizeWhitespace(htmlToText(in)); got != want {
+		t.Errorf("Expected:\n%q\ngot\n%q", want, got)
+	}
+}
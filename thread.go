@@ -0,0 +1,368 @@
+package mbox
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	reMsgID         = regexp.MustCompile(`<([^<>\s]+)>`)
+	reSubjectPrefix = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv)(\[\d+\]|\(\d+\))?\s*:\s*|\[[^\]]*\]\s*)`)
+)
+
+// ThreadMessage holds the headers of a message needed for threading, along
+// with the position of the message in its mbox.
+type ThreadMessage struct {
+	// Index and Offset locate the message, as reported by Reader.Index and
+	// Reader.Offset.
+	Index  int
+	Offset int64
+
+	MessageID  string
+	References []string
+	Subject    string
+	Date       time.Time
+}
+
+// Container is a node of a thread tree. Containers without a Message stand in
+// for messages that are referenced but not present in the archive.
+type Container struct {
+	Message  *ThreadMessage
+	Parent   *Container
+	Children []*Container
+}
+
+// NewThreadMessage extracts the threading information from a message header.
+// The In-Reply-To header is folded into References.
+func NewThreadMessage(index int, offset int64, h mail.Header) *ThreadMessage {
+	m := &ThreadMessage{
+		Index:   index,
+		Offset:  offset,
+		Subject: h.Get("Subject"),
+	}
+
+	if ids := parseMsgIDs(h.Get("Message-Id")); len(ids) > 0 {
+		m.MessageID = ids[0]
+	}
+
+	m.References = parseMsgIDs(h.Get("References"))
+	if ids := parseMsgIDs(h.Get("In-Reply-To")); len(ids) > 0 {
+		if n := len(m.References); n == 0 || m.References[n-1] != ids[0] {
+			m.References = append(m.References, ids[0])
+		}
+	}
+
+	if d, err := h.Date(); err == nil {
+		m.Date = d
+	}
+
+	return m
+}
+
+// parseMsgIDs returns the message identifiers found in a header value.
+func parseMsgIDs(v string) []string {
+	var ids []string
+	for _, m := range reMsgID.FindAllStringSubmatch(v, -1) {
+		ids = append(ids, m[1])
+	}
+
+	if ids == nil {
+		if v = strings.Trim(strings.TrimSpace(v), "<>"); v != "" && !strings.ContainsAny(v, " \t") {
+			ids = append(ids, v)
+		}
+	}
+
+	return ids
+}
+
+// ReadThreadMessages reads all messages from r and returns their threading
+// information. Message bodies are discarded as they are read. Messages with a
+// malformed header are threaded by the header fields read before the error.
+func ReadThreadMessages(r *Reader) ([]*ThreadMessage, error) {
+	var msgs []*ThreadMessage
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			return msgs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		h, err := textproto.NewReader(bufio.NewReader(msg)).ReadMIMEHeader()
+		var perr textproto.ProtocolError
+		if err != nil && err != io.EOF && !errors.As(err, &perr) {
+			return nil, err
+		}
+
+		msgs = append(msgs, NewThreadMessage(r.Index(), r.Offset(), mail.Header(h)))
+	}
+}
+
+// Thread arranges messages into conversation threads using Jamie Zawinski's
+// algorithm (https://www.jwz.org/doc/threading.html). Messages are linked by
+// Message-ID, In-Reply-To and References; threads whose roots share the same
+// base subject are then grouped together. It returns the root containers,
+// ordered by date like the children of every container.
+func Thread(msgs []*ThreadMessage) []*Container {
+	table := map[string]*Container{}
+	get := func(id string) *Container {
+		c, ok := table[id]
+		if !ok {
+			c = &Container{}
+			table[id] = c
+		}
+		return c
+	}
+
+	for _, m := range msgs {
+		id := m.MessageID
+		if id == "" || (table[id] != nil && table[id].Message != nil) {
+			// Missing or duplicate identifiers get a unique one.
+			id = "\x00" + strconv.Itoa(m.Index)
+		}
+
+		c := get(id)
+		c.Message = m
+
+		var prev *Container
+		for _, ref := range m.References {
+			rc := get(ref)
+			if prev != nil && rc.Parent == nil && rc != prev && !prev.reachableFrom(rc) {
+				rc.setParent(prev)
+			}
+			prev = rc
+		}
+
+		if prev != nil && !prev.reachableFrom(c) {
+			c.setParent(prev)
+		}
+	}
+
+	var roots []*Container
+	for _, c := range table {
+		if c.Parent == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	roots = pruneContainers(roots, true)
+	sortContainers(roots)
+	roots = groupBySubject(roots)
+	sortContainers(roots)
+
+	return roots
+}
+
+// reachableFrom reports whether c is a descendant of (or the same as) a.
+func (c *Container) reachableFrom(a *Container) bool {
+	for p := c; p != nil; p = p.Parent {
+		if p == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Container) setParent(p *Container) {
+	if c.Parent == p {
+		return
+	}
+
+	if c.Parent != nil {
+		c.Parent.removeChild(c)
+	}
+
+	c.Parent = p
+	if p != nil {
+		p.Children = append(p.Children, c)
+	}
+}
+
+func (c *Container) removeChild(child *Container) {
+	for i, x := range c.Children {
+		if x == child {
+			c.Children = append(c.Children[:i], c.Children[i+1:]...)
+			return
+		}
+	}
+}
+
+// pruneContainers removes empty containers without children and replaces
+// empty containers by their children, except at the root level where an
+// empty container is kept if it groups several children.
+func pruneContainers(cs []*Container, root bool) []*Container {
+	var out []*Container
+	for _, c := range cs {
+		c.Children = pruneContainers(c.Children, false)
+
+		switch {
+		case c.Message != nil:
+			out = append(out, c)
+		case len(c.Children) == 0:
+			// Dropped.
+		case !root || len(c.Children) == 1:
+			for _, child := range c.Children {
+				child.Parent = c.Parent
+			}
+			out = append(out, c.Children...)
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// baseSubject strips reply and forward prefixes from a subject and reports
+// whether there were any.
+func baseSubject(s string) (string, bool) {
+	reply := false
+	for {
+		loc := reSubjectPrefix.FindStringIndex(s)
+		if loc == nil {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(s[loc[0]:loc[1]]), "[") {
+			s = s[loc[1]:]
+			continue
+		}
+		reply = true
+		s = s[loc[1]:]
+	}
+
+	return strings.ToLower(strings.Join(strings.Fields(s), " ")), reply
+}
+
+// subject returns the subject of a container, or of its first child for
+// empty containers.
+func (c *Container) subject() (string, bool) {
+	if c.Message != nil {
+		return baseSubject(c.Message.Subject)
+	}
+	if len(c.Children) > 0 && c.Children[0].Message != nil {
+		return baseSubject(c.Children[0].Message.Subject)
+	}
+
+	return "", false
+}
+
+// groupBySubject merges root threads which share a base subject.
+func groupBySubject(roots []*Container) []*Container {
+	subjects := map[string]*Container{}
+	for _, c := range roots {
+		s, reply := c.subject()
+		if s == "" {
+			continue
+		}
+
+		old, ok := subjects[s]
+		if !ok {
+			subjects[s] = c
+			continue
+		}
+
+		_, oldReply := old.subject()
+		if (c.Message == nil && old.Message != nil) || (old.Message != nil && c.Message != nil && oldReply && !reply) {
+			subjects[s] = c
+		}
+	}
+
+	var out []*Container
+	for _, c := range roots {
+		s, reply := c.subject()
+		keep := subjects[s]
+		if s == "" || keep == c {
+			out = append(out, c)
+			continue
+		}
+
+		_, keepReply := keep.subject()
+		switch {
+		case keep.Message == nil && c.Message == nil:
+			for _, child := range append([]*Container(nil), c.Children...) {
+				child.setParent(keep)
+			}
+		case keep.Message == nil:
+			c.setParent(keep)
+		case c.Message != nil && !keepReply && reply:
+			c.setParent(keep)
+		default:
+			// Neither is a reply to the other: make them siblings under a
+			// new empty container.
+			p := &Container{}
+			replaced := false
+			for i, x := range out {
+				if x == keep {
+					out[i] = p
+					replaced = true
+				}
+			}
+			if !replaced {
+				out = append(out, p)
+			}
+			keep.setParent(p)
+			c.setParent(p)
+			subjects[s] = p
+		}
+	}
+
+	return out
+}
+
+// date returns the date of a container, which for empty containers is the
+// date of their earliest child.
+func (c *Container) date() (time.Time, int) {
+	if c.Message != nil {
+		return c.Message.Date, c.Message.Index
+	}
+
+	var (
+		d     time.Time
+		index = -1
+	)
+	for _, child := range c.Children {
+		cd, ci := child.date()
+		if index < 0 || cd.Before(d) || (cd.Equal(d) && ci < index) {
+			d, index = cd, ci
+		}
+	}
+
+	return d, index
+}
+
+func sortContainers(cs []*Container) {
+	for _, c := range cs {
+		sortContainers(c.Children)
+	}
+
+	sort.SliceStable(cs, func(i, j int) bool {
+		di, ii := cs[i].date()
+		dj, ij := cs[j].date()
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return ii < ij
+	})
+}
+
+// Walk calls fn for c and all of its descendants in depth-first order, with
+// the depth of each container relative to c.
+func (c *Container) Walk(fn func(c *Container, depth int)) {
+	c.walk(fn, 0)
+}
+
+func (c *Container) walk(fn func(*Container, int), depth int) {
+	fn(c, depth)
+	for _, child := range c.Children {
+		child.walk(fn, depth+1)
+	}
+}
@@ -0,0 +1,130 @@
+package mbox
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const mboxThreads = `From a@example.com Thu Jan  1 00:00:01 2015
+From: a@example.com
+Date: Thu, 01 Jan 2015 00:00:01 +0000
+Message-ID: <1@example.com>
+Subject: Lunch?
+
+Anyone?
+
+From b@example.com Thu Jan  1 00:00:03 2015
+From: b@example.com
+Date: Thu, 01 Jan 2015 00:00:03 +0000
+Message-ID: <3@example.com>
+References: <1@example.com> <2@example.com>
+In-Reply-To: <2@example.com>
+Subject: Re: Lunch?
+
+Sure.
+
+From c@example.com Thu Jan  1 00:00:02 2015
+From: c@example.com
+Date: Thu, 01 Jan 2015 00:00:02 +0000
+Message-ID: <4@example.com>
+In-Reply-To: <1@example.com>
+Subject: Re: Lunch?
+
+Where?
+
+From d@example.com Thu Jan  1 00:00:04 2015
+From: d@example.com
+Date: Thu, 01 Jan 2015 00:00:04 +0000
+Message-ID: <5@example.com>
+Subject: Re: [team] lunch?
+
+Lost my references.
+
+From e@example.com Thu Jan  1 00:00:05 2015
+From: e@example.com
+Date: Thu, 01 Jan 2015 00:00:05 +0000
+Subject: Unrelated
+
+Hi.
+`
+
+func dumpThreads(roots []*Container) string {
+	var sb strings.Builder
+	for _, root := range roots {
+		root.Walk(func(c *Container, depth int) {
+			sb.WriteString(strings.Repeat("  ", depth))
+			if c.Message == nil {
+				sb.WriteString("-\n")
+			} else {
+				fmt.Fprintf(&sb, "%d %s\n", c.Message.Index, c.Message.MessageID)
+			}
+		})
+	}
+
+	return sb.String()
+}
+
+func TestThread(t *testing.T) {
+	msgs, err := ReadThreadMessages(NewReader(strings.NewReader(mboxThreads)))
+	if err != nil {
+		t.Fatalf("ReadThreadMessages() = %v", err)
+	}
+
+	if len(msgs) != 5 || msgs[1].Offset <= msgs[0].Offset {
+		t.Fatalf("Unexpected thread messages: %+v", msgs)
+	}
+
+	// The missing <2@example.com> is pruned and its reply promoted.
+	want := "0 1@example.com\n  2 4@example.com\n  1 3@example.com\n  3 5@example.com\n4 \n"
+	if got := dumpThreads(Thread(msgs)); got != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestThreadMalformedHeader(t *testing.T) {
+	data := mboxThreads + "\nFrom f@example.com Thu Jan  1 00:00:06 2015\nFrom: f@example.com\nMessage-ID: <6@example.com>\nnot a header\nIn-Reply-To: <1@example.com>\n\nBroken.\n"
+	msgs, err := ReadThreadMessages(NewReader(strings.NewReader(data)))
+	if err != nil {
+		t.Fatalf("ReadThreadMessages() = %v", err)
+	}
+
+	if len(msgs) != 6 || msgs[5].MessageID != "6@example.com" || msgs[5].References != nil {
+		t.Fatalf("Unexpected thread message: %+v", msgs[len(msgs)-1])
+	}
+
+	if got := dumpThreads(Thread(msgs)); !strings.HasPrefix(got, "5 6@example.com\n0 ") {
+		t.Errorf("Expected the message as a root, got\n%s", got)
+	}
+}
+
+func TestThreadSubjectGrouping(t *testing.T) {
+	msgs := []*ThreadMessage{
+		{Index: 0, MessageID: "a", Subject: "Re: Status"},
+		{Index: 1, MessageID: "b", Subject: "RE: status"},
+		{Index: 2, MessageID: "c", Subject: "Status", References: []string{"c"}},
+	}
+
+	want := `2 c
+  0 a
+  1 b
+`
+	if got := dumpThreads(Thread(msgs)); got != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestBaseSubject(t *testing.T) {
+	tests := map[string]string{
+		"Re: Re[2]: Hello":   "hello",
+		"[list] Fwd:  Hello": "hello",
+		"AW: SV: hello":      "hello",
+		"Hello":              "hello",
+	}
+
+	for in, want := range tests {
+		if got, _ := baseSubject(in); got != want {
+			t.Errorf("baseSubject(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// thunderbirdSkip lists the extensions of the files found in a Thunderbird
+// mail directory which are not folders.
+var thunderbirdSkip = []string{".msf", ".dat", ".html", ".json", ".sqlite", ".mozlz4"}
+
+// ThunderbirdMessage describes a message read from a Thunderbird mail
+// directory.
+type ThunderbirdMessage struct {
+	// Folder is the slash-separated path of the folder holding the message,
+	// such as "Inbox" or "Archives/2020".
+	Folder string
+	// Path is the path of the mbox file of the folder.
+	Path string
+	// Index is the zero-based index of the message in its mbox file.
+	Index int
+	// Offset is the byte offset of the message in its mbox file.
+	Offset int64
+}
+
+// ThunderbirdReader reads the messages of all folders under a Thunderbird
+// mail directory, such as "Mail/Local Folders" or "ImapMail/imap.example.com"
+// in a profile. Each folder is an extensionless mbox file; its subfolders are
+// in a directory of the same name with a ".sbd" extension. Summary (.msf)
+// files and other files which do not hold an mbox are skipped. Folders are
+// read in name order, each followed by its subfolders.
+type ThunderbirdReader struct {
+	files mailboxFiles
+	cur   *ThunderbirdMessage
+}
+
+// NewThunderbirdReader returns a ThunderbirdReader for the mail directory at
+// dir. Messages which X-Mozilla-Status headers mark expunged are skipped, as
+// Thunderbird does.
+func NewThunderbirdReader(dir string) (*ThunderbirdReader, error) {
+	return NewThunderbirdReaderOptions(dir, &ReaderOptions{SkipExpunged: true})
+}
+
+// NewThunderbirdReaderOptions is like NewThunderbirdReader, with the options
+// used to read every folder. A nil opts skips no messages.
+func NewThunderbirdReaderOptions(dir string, opts *ReaderOptions) (*ThunderbirdReader, error) {
+	r := &ThunderbirdReader{files: mailboxFiles{opts: opts}}
+	if err := r.collect(dir, ""); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// collect adds the folders found in dir, whose parent folder is named
+// parent.
+func (r *ThunderbirdReader) collect(dir, parent string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]string{}
+	subdirs := map[string]string{}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+
+		if e.IsDir() {
+			if folder, ok := strings.CutSuffix(e.Name(), ".sbd"); ok {
+				subdirs[folder] = p
+			}
+			continue
+		}
+
+		if ok, err := isThunderbirdFolder(p, e); err != nil {
+			return err
+		} else if ok {
+			files[e.Name()] = p
+		}
+	}
+
+	// A folder may have subfolders without an mbox file of its own.
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	for name := range subdirs {
+		if _, ok := files[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		folder := path.Join(parent, name)
+		if p, ok := files[name]; ok {
+			r.files.boxes = append(r.files.boxes, mailboxFile{name: folder, path: p})
+		}
+		if p, ok := subdirs[name]; ok {
+			if err := r.collect(p, folder); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isThunderbirdFolder reports whether the file at p holds a folder: an empty
+// file or an mbox.
+func isThunderbirdFolder(p string, e os.DirEntry) (bool, error) {
+	if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+		return false, nil
+	}
+
+	ext := filepath.Ext(e.Name())
+	for _, s := range thunderbirdSkip {
+		if strings.EqualFold(ext, s) {
+			return false, nil
+		}
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	prefix := make([]byte, detectSize)
+	n, err := io.ReadFull(f, prefix)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+
+	return n == 0 || DetectFormat(prefix[:n]) == FormatMbox, nil
+}
+
+// NextMessage returns the next message text (containing both the header and the
+// body). It will return io.EOF if there are no messages left.
+func (r *ThunderbirdReader) NextMessage() (io.Reader, error) {
+	msg, err := r.files.next()
+	if err != nil {
+		r.cur = nil
+		return nil, err
+	}
+
+	f := &r.files
+	r.cur = &ThunderbirdMessage{Folder: f.box.name, Path: f.box.path, Index: f.r.Index(), Offset: f.r.Offset()}
+
+	return msg, nil
+}
+
+// Message describes the message most recently returned by NextMessage.
+func (r *ThunderbirdReader) Message() *ThunderbirdMessage {
+	return r.cur
+}
+
+// Close closes the mbox file being read.
+func (r *ThunderbirdReader) Close() error {
+	return r.files.close()
+}
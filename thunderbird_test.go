@@ -0,0 +1,87 @@
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// thunderbirdSubjects returns the folder and subject of every message read
+// from r.
+func thunderbirdSubjects(t *testing.T, r *ThunderbirdReader) string {
+	t.Helper()
+	defer r.Close()
+
+	var got []string
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		m, err := mail.ReadMessage(msg)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage() = %v", err)
+		}
+
+		if filepath.Ext(r.Message().Path) == ".msf" {
+			t.Errorf("Unexpected path %s", r.Message().Path)
+		}
+		got = append(got, r.Message().Folder+":"+m.Header.Get("Subject"))
+	}
+
+	return strings.Join(got, "|")
+}
+
+func TestThunderbirdReader(t *testing.T) {
+	dir := t.TempDir()
+	for p, data := range map[string]string{
+		"Inbox":                      mboxWithOneMessage,
+		"Inbox.msf":                  "// <!-- <mdb:mork:z v=\"1.4\"/> -->",
+		"Archives.sbd/2020":          mboxWithThreeMessages,
+		"Archives.sbd/2020.msf":      "",
+		"Archives.sbd/2019.sbd/Jan":  mboxWithOneMessage,
+		"Inbox.sbd/Lists":            mboxWithOneMessage,
+		"Inbox.sbd/Old":              mboxMozilla,
+		"Trash":                      "",
+		"msgFilterRules.dat":         "version=\"9\"\n",
+		"popstate.dat":               "# POP3 State File\n",
+		"filterlog.html":             "<html></html>",
+		"Drafts.sbd/Notes/README.md": "not a folder\n",
+		"notes.txt":                  "not a folder\n",
+	} {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewThunderbirdReader(dir)
+	if err != nil {
+		t.Fatalf("NewThunderbirdReader() = %v", err)
+	}
+
+	want := "Archives/2019/Jan:Test|Archives/2020:Test|Archives/2020:Another test|Archives/2020:A last test|Inbox:Test|Inbox/Lists:Test|Inbox/Old:Read|Inbox/Old:Replied and forwarded|Inbox/Old:IMAP deleted"
+	if got := thunderbirdSubjects(t, r); got != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, got)
+	}
+
+	// Without options, expunged messages are read too.
+	r, err = NewThunderbirdReaderOptions(filepath.Join(dir, "Inbox.sbd"), nil)
+	if err != nil {
+		t.Fatalf("NewThunderbirdReaderOptions() = %v", err)
+	}
+
+	want = "Lists:Test|Old:Read|Old:Expunged|Old:Replied and forwarded|Old:IMAP deleted"
+	if got := thunderbirdSubjects(t, r); got != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, got)
+	}
+}
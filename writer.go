@@ -0,0 +1,225 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variant identifies an mbox dialect. Dialects differ in how they protect body
+// lines starting with "From " from being taken as separators.
+type Variant int
+
+const (
+	// Mboxrd quotes lines matching ">*From " by adding a '>', which makes
+	// the quoting reversible.
+	Mboxrd Variant = iota
+	// Mboxo quotes lines starting with "From " as ">From ". Unquoting is
+	// ambiguous for lines that started with ">From " originally.
+	Mboxo
+	// Mboxcl quotes like Mboxo and adds a Content-Length header.
+	Mboxcl
+	// Mboxcl2 does not quote and relies on the Content-Length header.
+	Mboxcl2
+)
+
+var (
+	ErrWriterClosed   = errors.New("writer is closed")
+	ErrInvalidVariant = errors.New("invalid mbox variant")
+
+	reFromLine     = regexp.MustCompile(`(?m)^From `)
+	reQuotedOnce   = regexp.MustCompile(`(?m)^>From `)
+	reQuotedFrom   = regexp.MustCompile(`(?m)^>(>*From )`)
+	reAnyQuoteFrom = regexp.MustCompile(`(?m)^(>*From )`)
+)
+
+// String returns the conventional name of the variant.
+func (v Variant) String() string {
+	switch v {
+	case Mboxrd:
+		return "mboxrd"
+	case Mboxo:
+		return "mboxo"
+	case Mboxcl:
+		return "mboxcl"
+	case Mboxcl2:
+		return "mboxcl2"
+	}
+
+	return "Variant(" + strconv.Itoa(int(v)) + ")"
+}
+
+// ParseVariant returns the variant with the conventional name s, as returned
+// by Variant.String. Case is ignored.
+func ParseVariant(s string) (Variant, error) {
+	for _, v := range []Variant{Mboxrd, Mboxo, Mboxcl, Mboxcl2} {
+		if strings.EqualFold(s, v.String()) {
+			return v, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalidVariant, s)
+}
+
+// Unescape removes the quoting of "From " lines from the body of msg, a
+// message read from an mbox of the variant. The result has LF line endings.
+func (v Variant) Unescape(msg []byte) []byte {
+	header, body := splitMessage(normalizeNewlines(msg))
+
+	return joinMessage(header, v.unescape(body))
+}
+
+// escape quotes the "From " lines of a message body for the variant.
+func (v Variant) escape(body []byte) []byte {
+	switch v {
+	case Mboxrd:
+		return reAnyQuoteFrom.ReplaceAll(body, []byte(">$1"))
+	case Mboxo, Mboxcl:
+		return reFromLine.ReplaceAll(body, []byte(">From "))
+	}
+
+	return body
+}
+
+// unescape reverses escape. For Mboxo and Mboxcl, one level of quoting is
+// removed from ">From " lines only.
+func (v Variant) unescape(body []byte) []byte {
+	switch v {
+	case Mboxrd:
+		return reQuotedFrom.ReplaceAll(body, []byte("$1"))
+	case Mboxo, Mboxcl:
+		return reQuotedOnce.ReplaceAll(body, []byte("From "))
+	}
+
+	return body
+}
+
+// Writer writes messages to an mbox archive. Messages are written with LF line
+// endings.
+type Writer struct {
+	w       io.Writer
+	variant Variant
+	mw      *messageWriter
+	closed  bool
+}
+
+type messageWriter struct {
+	from string
+	date time.Time
+	buf  bytes.Buffer
+}
+
+func (mw *messageWriter) Write(p []byte) (int, error) {
+	return mw.buf.Write(p)
+}
+
+// NewWriter returns a Writer producing mboxrd data on w.
+func NewWriter(w io.Writer) *Writer {
+	return NewVariantWriter(w, Mboxrd)
+}
+
+// NewVariantWriter returns a Writer producing data of the given variant on w.
+func NewVariantWriter(w io.Writer, v Variant) *Writer {
+	return &Writer{w: w, variant: v}
+}
+
+// Variant returns the variant written by w.
+func (w *Writer) Variant() Variant {
+	return w.variant
+}
+
+// CreateMessage starts a new message with the given envelope sender and
+// delivery date, and returns a writer for its content (both the header and
+// the body). The message is written out when the next message is created or
+// the Writer is closed. An empty sender is written as MAILER-DAEMON and a zero
+// date as the current time.
+func (w *Writer) CreateMessage(from string, t time.Time) (io.Writer, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	w.mw = &messageWriter{from: from, date: t}
+
+	return w.mw, nil
+}
+
+// WriteMessage writes the message read from r with the given envelope sender
+// and delivery date.
+func (w *Writer) WriteMessage(from string, t time.Time, r io.Reader) error {
+	mw, err := w.CreateMessage(from, t)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(mw, r)
+
+	return err
+}
+
+// Close writes out the last message. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	w.closed = true
+
+	return nil
+}
+
+func (w *Writer) flush() error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	if w.mw == nil {
+		return nil
+	}
+
+	mw := w.mw
+	w.mw = nil
+
+	_, err := w.w.Write(formatMessage(mw.from, mw.date, mw.buf.Bytes(), w.variant))
+
+	return err
+}
+
+// formatSeparator returns the "From " line for a message, with line ending.
+func formatSeparator(from string, t time.Time) string {
+	return "From " + from + " " + t.UTC().Format(time.ANSIC) + "\n"
+}
+
+// formatMessage returns a message as it is stored in an mbox of the variant:
+// its separator line, header, escaped body and the blank line ending it.
+func formatMessage(from string, t time.Time, msg []byte, v Variant) []byte {
+	return append([]byte(formatSeparator(from, t)), formatEntry(msg, v)...)
+}
+
+// formatEntry is like formatMessage, without the separator line.
+func formatEntry(msg []byte, v Variant) []byte {
+	header, body := splitMessage(normalizeNewlines(msg))
+
+	body = v.escape(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body, '\n')
+	}
+
+	if v == Mboxcl || v == Mboxcl2 {
+		header = headerDel(header, "Content-Length")
+		header = headerAdd(header, "Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return append(joinMessage(header, body), '\n')
+}
@@ -0,0 +1,126 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterVariants(t *testing.T) {
+	msg := "Subject: Test\r\nContent-Length: 1\r\n\r\nFrom here.\r\n>From there.\r\nBye."
+	date := time.Date(2015, 1, 1, 0, 0, 1, 0, time.UTC)
+
+	tests := map[Variant]string{
+		Mboxrd:  "From a@example.com Thu Jan  1 00:00:01 2015\nSubject: Test\nContent-Length: 1\n\n>From here.\n>>From there.\nBye.\n\n",
+		Mboxo:   "From a@example.com Thu Jan  1 00:00:01 2015\nSubject: Test\nContent-Length: 1\n\n>From here.\n>From there.\nBye.\n\n",
+		Mboxcl:  "From a@example.com Thu Jan  1 00:00:01 2015\nSubject: Test\nContent-Length: 30\n\n>From here.\n>From there.\nBye.\n\n",
+		Mboxcl2: "From a@example.com Thu Jan  1 00:00:01 2015\nSubject: Test\nContent-Length: 29\n\nFrom here.\n>From there.\nBye.\n\n",
+	}
+
+	for v, want := range tests {
+		var b bytes.Buffer
+		w := NewVariantWriter(&b, v)
+		if err := w.WriteMessage("a@example.com", date, strings.NewReader(msg)); err != nil {
+			t.Fatalf("WriteMessage() = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+
+		if b.String() != want {
+			t.Errorf("%v - Expected:\n%q\ngot\n%q", v, want, b.String())
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+
+	r := NewReader(strings.NewReader(mboxWithThreeMessages))
+	for {
+		msg, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error after NextMessage(): %v", err)
+		}
+
+		from, date, err := ParseSeparator(r.Separator())
+		if err != nil {
+			t.Fatalf("ParseSeparator(%q) = %v", r.Separator(), err)
+		}
+
+		if err := w.WriteMessage(from, date, msg); err != nil {
+			t.Fatalf("WriteMessage() = %v", err)
+		}
+	}
+	w.Close()
+
+	if _, err := w.CreateMessage("", time.Time{}); err != ErrWriterClosed {
+		t.Errorf("Expected ErrWriterClosed, got %v", err)
+	}
+
+	// Re-escaping the mboxo input as mboxrd quotes ">From" once more. The
+	// weekday of the last separator line is wrong in the input, and the input
+	// lacks the blank line ending the last message.
+	want := strings.ReplaceAll(mboxWithThreeMessages, "\n>From Herp", "\n>>From Herp")
+	want = strings.Replace(want, "Thu Jan  3", "Sat Jan  3", 1) + "\n"
+	if b.String() != want {
+		t.Errorf("Expected:\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+func TestParseSeparator(t *testing.T) {
+	tests := []struct {
+		line   string
+		sender string
+		date   time.Time
+	}{
+		{"From herp.derp@example.com Thu Jan  1 00:00:01 2015", "herp.derp@example.com", time.Date(2015, 1, 1, 0, 0, 1, 0, time.UTC)},
+		{"From 1497368541312098712@xxx Sat Jun 03 11:42:37 +0000 2017", "1497368541312098712@xxx", time.Date(2017, 6, 3, 11, 42, 37, 0, time.UTC)},
+		{"From MAILER-DAEMON Fri Jul  8 12:08:34 2011 -0700", "MAILER-DAEMON", time.Date(2011, 7, 8, 19, 8, 34, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		sender, date, err := ParseSeparator(tt.line)
+		if err != nil || sender != tt.sender || !date.Equal(tt.date) {
+			t.Errorf("ParseSeparator(%q) = %q, %v, %v", tt.line, sender, date, err)
+		}
+	}
+
+	if _, _, err := ParseSeparator("From nobody"); err != ErrInvalidSeparator {
+		t.Errorf("Expected ErrInvalidSeparator, got %v", err)
+	}
+}
+
+func TestParseVariant(t *testing.T) {
+	for _, v := range []Variant{Mboxrd, Mboxo, Mboxcl, Mboxcl2} {
+		if got, err := ParseVariant(strings.ToUpper(v.String())); err != nil || got != v {
+			t.Errorf("ParseVariant(%q) = %v, %v", v, got, err)
+		}
+	}
+
+	if _, err := ParseVariant("mmdf"); !errors.Is(err, ErrInvalidVariant) {
+		t.Errorf("Expected ErrInvalidVariant, got %v", err)
+	}
+}
+
+func TestVariantUnescape(t *testing.T) {
+	msg := "Subject: Test\r\n>From: not unquoted\r\n\r\n>From here.\r\n>>From there.\r\n"
+
+	tests := map[Variant]string{
+		Mboxrd:  "Subject: Test\n>From: not unquoted\n\nFrom here.\n>From there.\n",
+		Mboxo:   "Subject: Test\n>From: not unquoted\n\nFrom here.\n>>From there.\n",
+		Mboxcl2: "Subject: Test\n>From: not unquoted\n\n>From here.\n>>From there.\n",
+	}
+
+	for v, want := range tests {
+		if got := string(v.Unescape([]byte(msg))); got != want {
+			t.Errorf("%v - Expected %q, got %q", v, want, got)
+		}
+	}
+}